@@ -0,0 +1,27 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bearerScheme is the authentication scheme of the API tokens.
+const bearerScheme = "Bearer "
+
+// authorize rejects requests without a valid "Authorization: Bearer <token>" header.
+// It lets every request through when no API token is configured; the server
+// warns about it on startup.
+func (s Server) authorize(ctx *gin.Context) {
+	if len(s.apiTokens) == 0 {
+		return
+	}
+
+	header := ctx.GetHeader("Authorization")
+	// the scheme is case-insensitive, but it may not be omitted
+	if len(header) < len(bearerScheme) || !strings.EqualFold(header[:len(bearerScheme)], bearerScheme) ||
+		!validToken(s.apiTokens, header[len(bearerScheme):]) {
+		s.log.Printf("AUDIT: rejected API request %v %v from %v: invalid token", ctx.Request.Method, ctx.Request.URL.Path, ctx.ClientIP())
+		ctx.AbortWithStatusJSON(401, "Unauthorized")
+	}
+}
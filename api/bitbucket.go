@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/reedom/satishub/pkg/satis"
+)
+
+// bitbucketPayload covers both of Bitbucket Cloud and Bitbucket Server push payloads.
+type bitbucketPayload struct {
+	// Bitbucket Cloud
+	Push struct {
+		Changes []struct {
+			New *struct {
+				Name string `json:"name"`
+			} `json:"new"`
+		} `json:"changes"`
+	} `json:"push"`
+
+	// Bitbucket Server
+	Changes []struct {
+		Type string `json:"type"`
+	} `json:"changes"`
+
+	Repository struct {
+		// Bitbucket Cloud
+		FullName string `json:"full_name"`
+		// Bitbucket Server
+		Slug    string `json:"slug"`
+		Project struct {
+			Key string `json:"key"`
+		} `json:"project"`
+
+		Links struct {
+			HTML struct {
+				Href string `json:"href"`
+			} `json:"html"`
+			Clone []struct {
+				Href string `json:"href"`
+				Name string `json:"name"`
+			} `json:"clone"`
+		} `json:"links"`
+	} `json:"repository"`
+}
+
+// isServer determines whether the payload comes from Bitbucket Server.
+func (p bitbucketPayload) isServer() bool {
+	return p.Repository.FullName == "" && p.Repository.Slug != ""
+}
+
+// affected determines whether the push adds or updates any branches or tags.
+func (p bitbucketPayload) affected() bool {
+	if p.isServer() {
+		for _, c := range p.Changes {
+			if c.Type != "DELETE" {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, c := range p.Push.Changes {
+		if c.New != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// packageName derives the Composer package name from the repository path.
+func (p bitbucketPayload) packageName() string {
+	if p.isServer() {
+		return strings.ToLower(p.Repository.Project.Key + "/" + p.Repository.Slug)
+	}
+	return strings.ToLower(p.Repository.FullName)
+}
+
+// cloneURL returns the repository URL for the protocol, "ssh" or "https".
+func (p bitbucketPayload) cloneURL(protocol string) string {
+	if p.isServer() {
+		name := protocol
+		if name == "https" {
+			name = "http"
+		}
+		for _, link := range p.Repository.Links.Clone {
+			if link.Name == name {
+				return link.Href
+			}
+		}
+		return ""
+	}
+
+	// Bitbucket Cloud payloads do not contain clone links.
+	if p.Repository.FullName == "" {
+		return ""
+	}
+	u, err := url.Parse(p.Repository.Links.HTML.Href)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	if protocol == "https" {
+		return "https://" + u.Host + "/" + p.Repository.FullName + ".git"
+	}
+	return "git@" + u.Host + ":" + p.Repository.FullName + ".git"
+}
+
+func (s Server) handleBitbucket(ctx *gin.Context) {
+	body, err := ioutil.ReadAll(ctx.Request.Body)
+	if err != nil {
+		s.log.Println("failed to read Bitbucket WebHook content")
+		ctx.JSON(400, "Bad Request")
+		return
+	}
+
+	if 0 < len(s.bitbucketSecrets) {
+		sig := ctx.GetHeader("X-Hub-Signature")
+		if !validHMACSignatureAny(s.bitbucketSecrets, body, sig, "sha256=") {
+			s.log.Printf("AUDIT: rejected Bitbucket WebHook from %v: X-Hub-Signature mismatch", ctx.ClientIP())
+			ctx.JSON(401, "Unauthorized")
+			return
+		}
+	}
+
+	event := ctx.GetHeader("X-Event-Key")
+	if event == "diagnostics:ping" {
+		ctx.JSON(200, "OK")
+		return
+	}
+
+	var req bitbucketPayload
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		s.log.Println("Bitbucket WebHook content is broken?")
+		ctx.JSON(200, "OK")
+		return
+	}
+
+	if (event != "repo:push" && event != "repo:refs_changed") || !req.affected() {
+		if s.debug {
+			s.log.Printf("Bitbucket WebHook event %q ignored", event)
+		}
+		ctx.JSON(200, "OK")
+		return
+	}
+
+	repoURL := req.cloneURL(s.bitbucketClone)
+	if repoURL == "" {
+		if s.debug {
+			s.log.Println("repository URL not found in request payload")
+		}
+		ctx.JSON(200, "OK")
+		return
+	}
+
+	pkg := satis.PackageInfo{
+		Name:    queryPackageName(ctx),
+		Version: ctx.Query("version"),
+		URL:     repoURL,
+		Type:    "vcs",
+	}
+	s.derivePackageName(&pkg, req.packageName())
+
+	s.queuePackage(ctx, pkg)
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBitbucketCloudPush(t *testing.T) {
+	repo, cleanup := builtRepository(t, "acme/sample-repository")
+	defer cleanup()
+	f := newFakeService()
+	f.repoPath = repo
+	s := newTestServer(f, ServerParam{BitbucketClone: "ssh"})
+
+	w := postFixture(t, s, "/webhook/bitbucket", "bitbucket-push.json", map[string]string{"X-Event-Key": "repo:push"})
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"job_id":"partial-job"}`, w.Body.String())
+	pkg := receivePackage(t, f)
+	assert.Equal(t, "acme/sample-repository", pkg.Name)
+	assert.Equal(t, "git@bitbucket.org:Acme/Sample-Repository.git", pkg.URL)
+	assert.Equal(t, "vcs", pkg.Type)
+
+	s = newTestServer(f, ServerParam{BitbucketClone: "https"})
+	w = postFixture(t, s, "/webhook/bitbucket?name=acme/sample&version=^1.0", "bitbucket-push.json", map[string]string{"X-Event-Key": "repo:push"})
+	assert.Equal(t, 200, w.Code)
+	pkg = receivePackage(t, f)
+	assert.Equal(t, "acme/sample", pkg.Name)
+	assert.Equal(t, "^1.0", pkg.Version)
+	assert.Equal(t, "https://bitbucket.org/Acme/Sample-Repository.git", pkg.URL)
+
+	// a branch removal
+	body := []byte(`{
+		"repository": {"full_name": "Acme/Sample-Repository", "links": {"html": {"href": "https://bitbucket.org/Acme/Sample-Repository"}}},
+		"push": {"changes": [{"new": null}]}
+	}`)
+	w = post(t, s, "/webhook/bitbucket", body, map[string]string{"X-Event-Key": "repo:push"})
+	assert.Equal(t, 200, w.Code)
+
+	w = postFixture(t, s, "/webhook/bitbucket", "bitbucket-push.json", map[string]string{"X-Event-Key": "pullrequest:created"})
+	assert.Equal(t, 200, w.Code)
+	assert.Len(t, f.packages, 0)
+}
+
+func TestBitbucketServerTag(t *testing.T) {
+	repo, cleanup := builtRepository(t, "acme/sample-repository")
+	defer cleanup()
+	f := newFakeService()
+	f.repoPath = repo
+	s := newTestServer(f, ServerParam{BitbucketClone: "https"})
+
+	w := postFixture(t, s, "/webhook/bitbucket", "bitbucket-tag.json", map[string]string{"X-Event-Key": "repo:refs_changed"})
+	assert.Equal(t, 200, w.Code)
+	pkg := receivePackage(t, f)
+	assert.Equal(t, "acme/sample-repository", pkg.Name)
+	assert.Equal(t, "https://bitbucket.example.com/scm/acme/sample-repository.git", pkg.URL)
+
+	// a project key and slug different from the composer.json name lead to
+	// a full rebuild
+	f.repoPath = ""
+	w = postFixture(t, s, "/webhook/bitbucket", "bitbucket-tag.json", map[string]string{"X-Event-Key": "repo:refs_changed"})
+	assert.Equal(t, 200, w.Code)
+	pkg = receivePackage(t, f)
+	assert.Empty(t, pkg.Name)
+	assert.Equal(t, "https://bitbucket.example.com/scm/acme/sample-repository.git", pkg.URL)
+
+	// a tag removal
+	body, err := ioutil.ReadFile("fixtures/bitbucket-tag.json")
+	assert.NoError(t, err)
+	w = post(t, s, "/webhook/bitbucket", bytes.Replace(body, []byte(`"type": "ADD"`), []byte(`"type": "DELETE"`), 1), map[string]string{"X-Event-Key": "repo:refs_changed"})
+	assert.Equal(t, 200, w.Code)
+	assert.Len(t, f.packages, 0)
+}
+
+func TestBitbucketSignature(t *testing.T) {
+	body, err := ioutil.ReadFile("fixtures/bitbucket-tag.json")
+	assert.NoError(t, err)
+
+	mac := hmac.New(sha256.New, []byte("new-secret"))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	f := newFakeService()
+	s := newTestServer(f, ServerParam{BitbucketSecrets: []string{"old-secret", "new-secret"}, BitbucketClone: "ssh"})
+
+	w := post(t, s, "/webhook/bitbucket", body, map[string]string{
+		"X-Event-Key":     "repo:refs_changed",
+		"X-Hub-Signature": signature,
+	})
+	assert.Equal(t, 200, w.Code)
+	pkg := receivePackage(t, f)
+	assert.Equal(t, "ssh://git@bitbucket.example.com:7999/acme/sample-repository.git", pkg.URL)
+
+	w = post(t, s, "/webhook/bitbucket", body, map[string]string{
+		"X-Event-Key":     "repo:refs_changed",
+		"X-Hub-Signature": "sha256=0123abcd",
+	})
+	assert.Equal(t, 401, w.Code)
+
+	w = post(t, s, "/webhook/bitbucket", body, map[string]string{"X-Event-Key": "repo:refs_changed"})
+	assert.Equal(t, 401, w.Code)
+	assert.Len(t, f.packages, 0)
+}
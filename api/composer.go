@@ -0,0 +1,81 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/reedom/satishub/pkg/satis"
+)
+
+// packagesJSON handles GET and HEAD /packages.json from the Composer index.
+func (s Server) packagesJSON(ctx *gin.Context) {
+	file, ok := s.service.Index().PackagesJSON()
+	if !ok {
+		s.serveRepoFile(ctx, "packages.json")
+		return
+	}
+	serveIndexFile(ctx, file)
+}
+
+// metadata handles GET and HEAD /p2/{vendor}/{name}.json and
+// /p2/{vendor}/{name}~dev.json from the Composer index.
+func (s Server) metadata(ctx *gin.Context) {
+	index := s.service.Index()
+	if _, ok := index.PackagesJSON(); !ok {
+		s.serveRepoFile(ctx, "p2/"+ctx.Param("vendor")+"/"+ctx.Param("file"))
+		return
+	}
+	name, ok := indexName(ctx)
+	if !ok {
+		ctx.JSON(404, "Not Found")
+		return
+	}
+	file, ok := index.Metadata(name)
+	if !ok {
+		ctx.JSON(404, gin.H{"error": "package not found"})
+		return
+	}
+	serveIndexFile(ctx, file)
+}
+
+// providers handles GET and HEAD /providers/{vendor}/{name}.json, the
+// "providers-api" which lists the packages providing the package.
+func (s Server) providers(ctx *gin.Context) {
+	name, ok := indexName(ctx)
+	if !ok {
+		ctx.JSON(404, "Not Found")
+		return
+	}
+	file, ok := s.service.Index().Providers(name)
+	if !ok {
+		ctx.JSON(404, "Not Found")
+		return
+	}
+	serveIndexFile(ctx, file)
+}
+
+// indexName returns the package name of the "{vendor}/{file}" path, such as
+// "vendor/name" or "vendor/name~dev".
+func indexName(ctx *gin.Context) (string, bool) {
+	file := ctx.Param("file")
+	if !strings.HasSuffix(file, ".json") {
+		return "", false
+	}
+	return strings.ToLower(ctx.Param("vendor") + "/" + strings.TrimSuffix(file, ".json")), true
+}
+
+// serveIndexFile responds with the file, or with 304 for a conditional
+// request by its ETag or Last-Modified.
+func serveIndexFile(ctx *gin.Context, file *satis.IndexFile) {
+	ctx.Header("Content-Type", "application/json")
+	ctx.Header("ETag", file.ETag)
+	http.ServeContent(ctx.Writer, ctx.Request, "", file.LastModified, bytes.NewReader(file.Data))
+}
+
+// serveRepoFile responds with the file in the satis output directory as it is.
+func (s Server) serveRepoFile(ctx *gin.Context, path string) {
+	http.ServeFile(ctx.Writer, ctx.Request, filepath.Join(s.service.RepoPath(), filepath.FromSlash(path)))
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/reedom/satishub/pkg/satis"
+	"github.com/stretchr/testify/assert"
+)
+
+// newIndexFakeService returns a fake service with a Composer index of a
+// satis output directory which has one package.
+func newIndexFakeService(t *testing.T) (*fakeService, func()) {
+	dir, err := ioutil.TempDir("", "satis-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"packages.json":    `{"packages":[],"metadata-url":"/p2/%package%.json"}`,
+		"p2/a/b.json":      `{"packages":{"a/b":[{"name":"a/b","version":"1.0.0","provide":{"c/d":"1.0"}}]}}`,
+		"p2/a/b~dev.json":  `{"packages":{"a/b":[]}}`,
+		"index.html":       `<html></html>`,
+		"include/all.json": `{}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := newFakeService()
+	f.repoPath = dir
+	f.index = satis.NewComposerIndex(dir, filepath.Join(dir, "satis.json"))
+	return f, func() { os.RemoveAll(dir) }
+}
+
+func TestComposerIndexAPI(t *testing.T) {
+	f, cleanup := newIndexFakeService(t)
+	defer cleanup()
+	s := newTestServer(f, ServerParam{})
+
+	// the files are served as they are until the index is loaded
+	w := get(t, s, "/packages.json")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"packages":[],"metadata-url":"/p2/%package%.json"}`, w.Body.String())
+	assert.Empty(t, w.Header().Get("ETag"))
+
+	assert.NoError(t, f.index.Reload())
+	w = get(t, s, "/packages.json")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{
+		"packages": [],
+		"metadata-url": "/p2/%package%.json",
+		"providers-api": "/providers/%package%.json",
+		"available-packages": ["a/b"]
+	}`, w.Body.String())
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.NotEmpty(t, w.Header().Get("Last-Modified"))
+
+	w = get(t, s, "/p2/a/b.json")
+	assert.Equal(t, 200, w.Code)
+	etag := w.Header().Get("ETag")
+	lastModified := w.Header().Get("Last-Modified")
+	assert.NotEmpty(t, etag)
+	assert.Contains(t, w.Body.String(), `"version":"1.0.0"`)
+
+	w = getWithHeader(t, s, "/p2/a/b.json", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, 304, w.Code)
+	assert.Empty(t, w.Body.String())
+	w = getWithHeader(t, s, "/p2/a/b.json", map[string]string{"If-Modified-Since": lastModified})
+	assert.Equal(t, 304, w.Code)
+	w = getWithHeader(t, s, "/p2/a/b.json", map[string]string{"If-None-Match": `"other"`})
+	assert.Equal(t, 200, w.Code)
+
+	// HEAD is answered from the index as well, without the body
+	w = request(t, s, "HEAD", "/p2/a/b.json")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	assert.Empty(t, w.Body.String())
+	w = request(t, s, "HEAD", "/packages.json")
+	assert.Equal(t, 200, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Last-Modified"))
+	assert.Empty(t, w.Body.String())
+	w = request(t, s, "HEAD", "/p2/a/unknown.json")
+	assert.Equal(t, 404, w.Code)
+	w = request(t, s, "HEAD", "/providers/c/d.json")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	w = get(t, s, "/p2/a/b~dev.json")
+	assert.Equal(t, 200, w.Code)
+	w = get(t, s, "/p2/a/unknown.json")
+	assert.Equal(t, 404, w.Code)
+	w = get(t, s, "/p2/a/b")
+	assert.Equal(t, 404, w.Code)
+
+	w = get(t, s, "/providers/c/d.json")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"providers":[{"name":"a/b","description":"","type":""}]}`, w.Body.String())
+	w = get(t, s, "/providers/a/unknown.json")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"providers":[]}`, w.Body.String())
+
+	// the other files are served from the output directory
+	w = get(t, s, "/include/all.json")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{}`, w.Body.String())
+}
+
+func getWithHeader(t *testing.T, s Server, path string, header map[string]string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", path, nil)
+	assert.NoError(t, err)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	w := httptest.NewRecorder()
+	s.setupHandler().ServeHTTP(w, req)
+	return w
+}
@@ -1,11 +1,287 @@
 package api
 
 import (
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/reedom/satishub/pkg/satis"
 )
 
 func (s Server) readConfig(ctx *gin.Context) {
 	http.ServeFile(ctx.Writer, ctx.Request, s.service.ConfigPath())
 }
+
+// repositoryResponse is a "repositories" entry identified by its index.
+type repositoryResponse struct {
+	ID         int                    `json:"id"`
+	Repository map[string]interface{} `json:"repository"`
+}
+
+// requireRequest is the request body of PUT /api/v1/config/require/{vendor}/{name}.
+//
+//	{
+//	  "version": "^1.0"
+//	}
+type requireRequest struct {
+	Version string `json:"version" binding:"required"`
+}
+
+// getConfig handles GET /api/v1/config and responds with the satis config
+// as it is in the file.
+func (s Server) getConfig(ctx *gin.Context) {
+	data, err := ioutil.ReadFile(s.service.ConfigPath())
+	if err != nil {
+		s.respondConfigError(ctx, err)
+		return
+	}
+	ctx.Data(200, "application/json; charset=utf-8", data)
+}
+
+// patchConfig handles PATCH /api/v1/config which sets the top-level fields
+// such as "name", "homepage", "require-all" and "archive". A null value
+// removes the field. A full rebuild follows.
+func (s Server) patchConfig(ctx *gin.Context) {
+	var patch map[string]interface{}
+	if err := ctx.ShouldBindJSON(&patch); err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	err := s.service.EditConfig(configSource(ctx), func(configPath string) error {
+		return satis.PatchConfig(configPath, patch)
+	})
+	if err != nil {
+		s.respondConfigError(ctx, err)
+		return
+	}
+
+	config, err := satis.ReadConfig(s.service.ConfigPath())
+	if err != nil {
+		s.respondConfigError(ctx, err)
+		return
+	}
+	ctx.JSON(200, s.queueConfigBuild(ctx, "", gin.H{"config": config}))
+}
+
+func (s Server) listRepositories(ctx *gin.Context) {
+	repos, err := satis.ConfigRepositories(s.service.ConfigPath())
+	if err != nil {
+		s.respondConfigError(ctx, err)
+		return
+	}
+	res := make([]repositoryResponse, len(repos))
+	for i, repo := range repos {
+		res[i] = repositoryResponse{ID: i, Repository: repo}
+	}
+	ctx.JSON(200, res)
+}
+
+func (s Server) getRepository(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(404, "Not Found")
+		return
+	}
+	repos, err := satis.ConfigRepositories(s.service.ConfigPath())
+	if err != nil {
+		s.respondConfigError(ctx, err)
+		return
+	}
+	if id < 0 || len(repos) <= id {
+		ctx.JSON(404, "Not Found")
+		return
+	}
+	ctx.JSON(200, repositoryResponse{ID: id, Repository: repos[id]})
+}
+
+// addRepository handles POST /api/v1/config/repositories. The "name" query
+// parameter names the package of the repository to build only it;
+// otherwise a full rebuild follows.
+func (s Server) addRepository(ctx *gin.Context) {
+	var repo map[string]interface{}
+	if err := ctx.ShouldBindJSON(&repo); err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	name, ok := repositoryPackage(ctx, repo)
+	if !ok {
+		ctx.JSON(400, gin.H{"error": satis.ErrInvalidPackageName.Error()})
+		return
+	}
+
+	var id int
+	err := s.service.EditConfig(configSource(ctx), func(configPath string) (err error) {
+		id, err = satis.AddRepository(configPath, repo)
+		return err
+	})
+	if err != nil {
+		s.respondConfigError(ctx, err)
+		return
+	}
+	s.log.Printf("repository %d added from %v", id, ctx.ClientIP())
+	ctx.JSON(201, s.queueConfigBuild(ctx, name, gin.H{"id": id, "repository": repo}))
+}
+
+// patchRepository handles PATCH /api/v1/config/repositories/{id}. The body
+// is merged into the entry, and a null value removes the key. The build
+// follows as in addRepository.
+func (s Server) patchRepository(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(404, "Not Found")
+		return
+	}
+	var patch map[string]interface{}
+	if err := ctx.ShouldBindJSON(&patch); err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if _, ok := repositoryPackage(ctx, patch); !ok {
+		ctx.JSON(400, gin.H{"error": satis.ErrInvalidPackageName.Error()})
+		return
+	}
+
+	var repo map[string]interface{}
+	err = s.service.EditConfig(configSource(ctx), func(configPath string) (err error) {
+		repo, err = satis.PatchRepository(configPath, id, patch)
+		return err
+	})
+	if err != nil {
+		s.respondConfigError(ctx, err)
+		return
+	}
+	s.log.Printf("repository %d updated from %v", id, ctx.ClientIP())
+	name, _ := repositoryPackage(ctx, repo)
+	ctx.JSON(200, s.queueConfigBuild(ctx, name, gin.H{"id": id, "repository": repo}))
+}
+
+// deleteRepository handles DELETE /api/v1/config/repositories/{id}.
+// A full rebuild follows to drop the packages of the repository.
+func (s Server) deleteRepository(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(404, "Not Found")
+		return
+	}
+	var repo map[string]interface{}
+	err = s.service.EditConfig(configSource(ctx), func(configPath string) (err error) {
+		repo, err = satis.DeleteRepository(configPath, id)
+		return err
+	})
+	if err != nil {
+		s.respondConfigError(ctx, err)
+		return
+	}
+	s.log.Printf("repository %d deleted from %v", id, ctx.ClientIP())
+	ctx.JSON(200, s.queueConfigBuild(ctx, "", gin.H{"id": id, "repository": repo}))
+}
+
+// repositoryPackage returns the package name of the repository entry: the
+// "name" query parameter, or the name of an inline "package" definition.
+// It returns false for an invalid name.
+func repositoryPackage(ctx *gin.Context, repo map[string]interface{}) (string, bool) {
+	name := ctx.Query("name")
+	if name == "" && repo["type"] == "package" {
+		if pkg, ok := repo["package"].(map[string]interface{}); ok {
+			name, _ = pkg["name"].(string)
+		}
+	}
+	name = strings.ToLower(name)
+	return name, name == "" || satis.ValidPackageName(name)
+}
+
+func (s Server) listRequires(ctx *gin.Context) {
+	requires, err := satis.ConfigRequires(s.service.ConfigPath())
+	if err != nil {
+		s.respondConfigError(ctx, err)
+		return
+	}
+	ctx.JSON(200, requires)
+}
+
+// setRequire handles PUT /api/v1/config/require/{vendor}/{name} and builds
+// the package.
+func (s Server) setRequire(ctx *gin.Context) {
+	name := strings.ToLower(ctx.Param("vendor") + "/" + ctx.Param("name"))
+	if !satis.ValidPackageName(name) {
+		ctx.JSON(400, gin.H{"error": satis.ErrInvalidPackageName.Error()})
+		return
+	}
+	var req requireRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := s.service.EditConfig(configSource(ctx), func(configPath string) error {
+		return satis.SetRequire(configPath, name, req.Version)
+	})
+	if err != nil {
+		s.respondConfigError(ctx, err)
+		return
+	}
+	s.log.Printf("require %v %v set from %v", name, req.Version, ctx.ClientIP())
+	ctx.JSON(200, s.queueConfigBuild(ctx, name, gin.H{"name": name, "version": req.Version}))
+}
+
+// deleteRequire handles DELETE /api/v1/config/require/{vendor}/{name}.
+// A full rebuild follows to drop the package.
+func (s Server) deleteRequire(ctx *gin.Context) {
+	name := strings.ToLower(ctx.Param("vendor") + "/" + ctx.Param("name"))
+	err := s.service.EditConfig(configSource(ctx), func(configPath string) error {
+		return satis.RemoveRequire(configPath, name)
+	})
+	if err != nil {
+		s.respondConfigError(ctx, err)
+		return
+	}
+	s.log.Printf("require %v removed from %v", name, ctx.ClientIP())
+	ctx.JSON(200, s.queueConfigBuild(ctx, "", gin.H{"name": name}))
+}
+
+// configSource describes the config edit request for the config history.
+func configSource(ctx *gin.Context) string {
+	return ctx.Request.Method + " " + ctx.Request.URL.Path
+}
+
+// queueConfigBuild queues the build after a config edit: a partial build of
+// the package when name is given, or a full rebuild. It returns res with
+// "job_id", or with "build_error" when the build could not be queued; the
+// edit itself has already been written.
+func (s Server) queueConfigBuild(ctx *gin.Context, name string, res gin.H) gin.H {
+	var jobID string
+	var err error
+	if name != "" {
+		jobID, _, err = s.service.UpdatePackage(satis.PackageInfo{Name: name})
+	} else {
+		jobID, _, err = s.service.Rebuild()
+	}
+	if err != nil {
+		s.log.Println("ERROR:", err.Error())
+		res["build_error"] = err.Error()
+		return res
+	}
+	if s.debug {
+		s.log.Printf("job %v: build after config edit from %v", jobID, ctx.ClientIP())
+	}
+	res["job_id"] = jobID
+	return res
+}
+
+// respondConfigError responds with 404 for a missing entry, 422 for an edit
+// which makes the config invalid, or 500.
+func (s Server) respondConfigError(ctx *gin.Context, err error) {
+	if verr, ok := errors.Cause(err).(*satis.ValidationError); ok {
+		ctx.JSON(422, gin.H{"error": verr.Error(), "errors": verr.Errors})
+		return
+	}
+	if errors.Cause(err) == satis.ErrConfigEntryNotFound {
+		ctx.JSON(404, "Not Found")
+		return
+	}
+	s.log.Println("ERROR:", err.Error())
+	ctx.JSON(500, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newConfigFakeService returns a fake service with a temporary satis config.
+func newConfigFakeService(t *testing.T, content string) (*fakeService, func()) {
+	tmp, err := ioutil.TempFile("", "satis-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp.WriteString(content)
+	tmp.Close()
+
+	f := newFakeService()
+	f.configPath = tmp.Name()
+	return f, func() {
+		os.Remove(tmp.Name())
+		os.Remove(tmp.Name() + ".lock")
+	}
+}
+
+const testConfig = `{
+  "name": "My Satis Repository",
+  "repositories": [
+    { "type": "vcs", "url": "git@example.com:vendor/a.git" }
+  ],
+  "require": {
+    "vendor/a": "*"
+  }
+}`
+
+func TestConfigRepositoriesAPI(t *testing.T) {
+	f, cleanup := newConfigFakeService(t, testConfig)
+	defer cleanup()
+	s := newTestServer(f, ServerParam{})
+
+	w := send(t, s, "POST", "/api/v1/config/repositories?name=vendor/b", []byte(`{"type":"git","url":"git@example.com:vendor/b.git"}`))
+	assert.Equal(t, 201, w.Code)
+	var res struct {
+		ID         int                    `json:"id"`
+		Repository map[string]interface{} `json:"repository"`
+		JobID      string                 `json:"job_id"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, 1, res.ID)
+	assert.Equal(t, "partial-job", res.JobID)
+	assert.Equal(t, "vendor/b", receivePackage(t, f).Name)
+
+	w = get(t, s, "/api/v1/config/repositories/1")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"id":1,"repository":{"type":"git","url":"git@example.com:vendor/b.git"}}`, w.Body.String())
+
+	w = send(t, s, "PATCH", "/api/v1/config/repositories/0", []byte(`{"type":"git"}`))
+	assert.Equal(t, 200, w.Code)
+	assert.Len(t, f.rebuilds, 1)
+
+	w = send(t, s, "DELETE", "/api/v1/config/repositories/1", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Len(t, f.rebuilds, 2)
+
+	w = get(t, s, "/api/v1/config/repositories")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `[{"id":0,"repository":{"type":"git","url":"git@example.com:vendor/a.git"}}]`, w.Body.String())
+
+	w = send(t, s, "DELETE", "/api/v1/config/repositories/1", nil)
+	assert.Equal(t, 404, w.Code)
+	assert.Len(t, f.rebuilds, 2)
+}
+
+func TestConfigRepositoriesAPIInvalid(t *testing.T) {
+	f, cleanup := newConfigFakeService(t, testConfig)
+	defer cleanup()
+	s := newTestServer(f, ServerParam{})
+
+	w := send(t, s, "POST", "/api/v1/config/repositories", []byte(`{"type":"vcs","options":[]}`))
+	assert.Equal(t, 422, w.Code)
+	assert.JSONEq(t, `[
+		{"pointer":"/repositories/1/url","message":"must be a non-empty string"},
+		{"pointer":"/repositories/1/options","message":"must be an object"}
+	]`, string(errorsOf(t, w.Body.Bytes())))
+
+	w = send(t, s, "POST", "/api/v1/config/repositories?name=Invalid", []byte(`{"type":"vcs","url":"http://example.com/b"}`))
+	assert.Equal(t, 400, w.Code)
+
+	data, err := ioutil.ReadFile(f.configPath)
+	assert.NoError(t, err)
+	assert.Equal(t, testConfig, string(data))
+	assert.Len(t, f.packages, 0)
+	assert.Len(t, f.rebuilds, 0)
+}
+
+func TestConfigRequireAPI(t *testing.T) {
+	f, cleanup := newConfigFakeService(t, testConfig)
+	defer cleanup()
+	s := newTestServer(f, ServerParam{})
+
+	w := send(t, s, "PUT", "/api/v1/config/require/vendor/b", []byte(`{"version":"^1.0"}`))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "vendor/b", receivePackage(t, f).Name)
+
+	w = send(t, s, "DELETE", "/api/v1/config/require/vendor/a", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Len(t, f.rebuilds, 1)
+
+	w = get(t, s, "/api/v1/config/require")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"vendor/b":"^1.0"}`, w.Body.String())
+
+	w = send(t, s, "DELETE", "/api/v1/config/require/vendor/a", nil)
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestConfigPatchAPI(t *testing.T) {
+	f, cleanup := newConfigFakeService(t, testConfig)
+	defer cleanup()
+	s := newTestServer(f, ServerParam{})
+
+	w := send(t, s, "PATCH", "/api/v1/config", []byte(`{"description":null,"homepage":"https://packages.example.com","archive":{"directory":"dist"}}`))
+	assert.Equal(t, 200, w.Code)
+	assert.Len(t, f.rebuilds, 1)
+
+	w = get(t, s, "/api/v1/config")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{
+		"name": "My Satis Repository",
+		"repositories": [{"type":"vcs","url":"git@example.com:vendor/a.git"}],
+		"require": {"vendor/a":"*"},
+		"homepage": "https://packages.example.com",
+		"archive": {"directory":"dist"}
+	}`, w.Body.String())
+
+	w = send(t, s, "PATCH", "/api/v1/config", []byte(`{"require-all":"yes","repositories":[]}`))
+	assert.Equal(t, 422, w.Code)
+	w = send(t, s, "PATCH", "/api/v1/config", []byte(`{"name":null}`))
+	assert.Equal(t, 422, w.Code)
+	assert.JSONEq(t, `[{"pointer":"/name","message":"is required"}]`, string(errorsOf(t, w.Body.Bytes())))
+	assert.Len(t, f.rebuilds, 1)
+}
+
+// errorsOf returns the "errors" of the error response.
+func errorsOf(t *testing.T, body []byte) json.RawMessage {
+	var res struct {
+		Errors json.RawMessage `json:"errors"`
+	}
+	assert.NoError(t, json.Unmarshal(body, &res))
+	return res.Errors
+}
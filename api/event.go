@@ -0,0 +1,79 @@
+package api
+
+import (
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/reedom/satishub/pkg/satis"
+)
+
+// sseKeepAlive is the interval of keep-alive events on idle streams.
+var sseKeepAlive = 30 * time.Second
+
+// streamJobEvents streams the job state transitions and the satis output
+// as Server-Sent Events until the job finishes.
+func (s Server) streamJobEvents(ctx *gin.Context) {
+	sub, ok := s.service.Subscribe(ctx.Param("id"))
+	if !ok {
+		ctx.JSON(404, "Not Found")
+		return
+	}
+	defer sub.Close()
+
+	ctx.SSEvent(satis.JobEventState, sub.Job)
+	if 0 < len(sub.Output) {
+		ctx.SSEvent(satis.JobEventOutput, string(sub.Output))
+	}
+	if sub.Job.State.Finished() {
+		return
+	}
+
+	s.streamEvents(ctx, sub.Events, func(ev satis.JobEvent) bool {
+		return ev.Type != satis.JobEventState || !ev.Job.State.Finished()
+	}, func() bool {
+		// the finished state event may have been lost
+		job, ok := s.service.Job(sub.Job.ID)
+		if ok && job.State.Finished() {
+			ctx.SSEvent(satis.JobEventState, job)
+			return false
+		}
+		return ok
+	})
+}
+
+// streamAllJobEvents streams the state transitions of all jobs as Server-Sent Events.
+func (s Server) streamAllJobEvents(ctx *gin.Context) {
+	sub, _ := s.service.Subscribe("")
+	defer sub.Close()
+
+	s.streamEvents(ctx, sub.Events, func(satis.JobEvent) bool { return true }, func() bool { return true })
+}
+
+// streamEvents writes the events until cont returns false or the client leaves.
+// On each keep-alive, idle tells whether to go on.
+func (s Server) streamEvents(ctx *gin.Context, events <-chan satis.JobEvent, cont func(satis.JobEvent) bool, idle func() bool) {
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case <-ctx.Request.Context().Done():
+			return false
+		case <-ticker.C:
+			if !idle() {
+				return false
+			}
+			ctx.SSEvent("ping", "")
+			return true
+		case ev := <-events:
+			switch ev.Type {
+			case satis.JobEventState:
+				ctx.SSEvent(ev.Type, ev.Job)
+			case satis.JobEventOutput:
+				ctx.SSEvent(ev.Type, string(ev.Output))
+			}
+			return cont(ev)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/reedom/satishub/pkg/satis"
+)
+
+// listGenerations handles GET /api/v1/generations and responds with the
+// output generations, the newest first.
+func (s Server) listGenerations(ctx *gin.Context) {
+	gens, err := s.service.Generations()
+	if err != nil {
+		s.respondGenerationError(ctx, err)
+		return
+	}
+	if gens == nil {
+		gens = []satis.Generation{}
+	}
+	ctx.JSON(200, gens)
+}
+
+// switchGeneration handles POST /api/v1/generations/{id}/switch which
+// points the output directory to the generation.
+func (s Server) switchGeneration(ctx *gin.Context) {
+	id := ctx.Param("id")
+	jobID, ch, err := s.service.SwitchGeneration(id)
+	if err != nil {
+		s.respondGenerationError(ctx, err)
+		return
+	}
+	s.log.Printf("job %v: switch to generation %s requested from %v", jobID, id, ctx.ClientIP())
+	s.respondTrigger(ctx, jobID, ch)
+}
+
+// respondGenerationError responds with 404 when the generations or the
+// generation is missing.
+func (s Server) respondGenerationError(ctx *gin.Context, err error) {
+	switch err {
+	case satis.ErrGenerationsDisabled, satis.ErrGenerationNotFound:
+		ctx.JSON(404, gin.H{"error": err.Error()})
+	case satis.ErrQueueFull:
+		s.respondQueueError(ctx, err)
+	default:
+		s.log.Println("ERROR:", err.Error())
+		ctx.JSON(500, gin.H{"error": err.Error()})
+	}
+}
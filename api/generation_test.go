@@ -0,0 +1,37 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/reedom/satishub/pkg/satis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerationsAPI(t *testing.T) {
+	f := newFakeService()
+	s := newTestServer(f, ServerParam{})
+
+	w := get(t, s, "/api/v1/generations")
+	assert.Equal(t, 404, w.Code)
+
+	f.generations = []satis.Generation{
+		{ID: "20180313-040000.000000", Time: time.Date(2018, 3, 13, 4, 0, 0, 0, time.UTC), Current: true},
+		{ID: "20180313-030000.000000", Time: time.Date(2018, 3, 13, 3, 0, 0, 0, time.UTC)},
+	}
+	w = get(t, s, "/api/v1/generations")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `[
+		{"id":"20180313-040000.000000","time":"2018-03-13T04:00:00Z","current":true},
+		{"id":"20180313-030000.000000","time":"2018-03-13T03:00:00Z","current":false}
+	]`, w.Body.String())
+
+	w = send(t, s, "POST", "/api/v1/generations/20180313-030000.000000/switch", nil)
+	assert.Equal(t, 202, w.Code)
+	assert.JSONEq(t, `{"job_id":"switch-job"}`, w.Body.String())
+	assert.Equal(t, "20180313-030000.000000", <-f.switches)
+
+	w = send(t, s, "POST", "/api/v1/generations/unknown/switch", nil)
+	assert.Equal(t, 404, w.Code)
+	assert.Len(t, f.switches, 0)
+}
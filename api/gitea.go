@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/reedom/satishub/pkg/satis"
+)
+
+type giteaPayload struct {
+	Ref     string `json:"ref"`
+	After   string `json:"after"`
+	RefType string `json:"ref_type"`
+	Action  string `json:"action"`
+
+	Repository struct {
+		FullName string `json:"full_name"`
+		CloneURL string `json:"clone_url"`
+		SSHURL   string `json:"ssh_url"`
+	} `json:"repository"`
+}
+
+// giteaHeader returns the header value sent by either of Gitea, Forgejo or Gogs.
+// name is the header name without the vendor prefix, such as "Event".
+func giteaHeader(ctx *gin.Context, name string) string {
+	for _, vendor := range []string{"Gitea", "Forgejo", "Gogs"} {
+		if value := ctx.GetHeader("X-" + vendor + "-" + name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// giteaShouldBuild determines whether the event affects the package repository.
+func giteaShouldBuild(event string, req giteaPayload) bool {
+	switch event {
+	case "push":
+		// branch or tag removal
+		return strings.Trim(req.After, "0") != ""
+	case "create":
+		return req.RefType == "tag"
+	case "release":
+		return req.Action == "published"
+	}
+	return false
+}
+
+func (s Server) handleGitea(ctx *gin.Context) {
+	body, err := ioutil.ReadAll(ctx.Request.Body)
+	if err != nil {
+		s.log.Println("failed to read Gitea WebHook content")
+		ctx.JSON(400, "Bad Request")
+		return
+	}
+
+	if 0 < len(s.giteaSecrets) {
+		sig := giteaHeader(ctx, "Signature")
+		if !validHMACSignatureAny(s.giteaSecrets, body, sig, "") {
+			s.log.Printf("AUDIT: rejected Gitea WebHook from %v: X-Gitea-Signature mismatch", ctx.ClientIP())
+			ctx.JSON(401, "Unauthorized")
+			return
+		}
+	}
+
+	var req giteaPayload
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		s.log.Println("Gitea WebHook content is broken?")
+		ctx.JSON(200, "OK")
+		return
+	}
+
+	event := giteaHeader(ctx, "Event")
+	if !giteaShouldBuild(event, req) {
+		if s.debug {
+			s.log.Printf("Gitea WebHook event %q ignored", event)
+		}
+		ctx.JSON(200, "OK")
+		return
+	}
+
+	if req.Repository.SSHURL == "" {
+		if s.debug {
+			s.log.Println("repository URL not found in request payload")
+		}
+		ctx.JSON(200, "OK")
+		return
+	}
+
+	pkg := satis.PackageInfo{
+		Name:    queryPackageName(ctx),
+		Version: ctx.Query("version"),
+		URL:     req.Repository.SSHURL,
+		Type:    "vcs",
+	}
+	s.derivePackageName(&pkg, strings.ToLower(req.Repository.FullName))
+
+	s.queuePackage(ctx, pkg)
+}
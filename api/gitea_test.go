@@ -0,0 +1,96 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/reedom/satishub/pkg/satis"
+	"github.com/stretchr/testify/assert"
+)
+
+func receivePackage(t *testing.T, f *fakeService) satis.PackageInfo {
+	select {
+	case pkg := <-f.packages:
+		return pkg
+	case <-time.After(time.Second):
+		t.Fatal("UpdatePackage not called")
+	}
+	return satis.PackageInfo{}
+}
+
+func TestGiteaPush(t *testing.T) {
+	repo, cleanup := builtRepository(t, "all/sample-repository")
+	defer cleanup()
+	f := newFakeService()
+	f.repoPath = repo
+	s := newTestServer(f, ServerParam{})
+
+	w := postFixture(t, s, "/webhook/gitea", "gitea-push.json", map[string]string{"X-Gitea-Event": "push"})
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"job_id":"partial-job"}`, w.Body.String())
+
+	pkg := receivePackage(t, f)
+	assert.Equal(t, "all/sample-repository", pkg.Name)
+	assert.Equal(t, "git@gitea.example.com:all/Sample-Repository.git", pkg.URL)
+	assert.Equal(t, "vcs", pkg.Type)
+
+	// a name not in the repository leads to a full rebuild
+	f.repoPath = ""
+	w = postFixture(t, s, "/webhook/gitea", "gitea-push.json", map[string]string{"X-Gitea-Event": "push"})
+	assert.Equal(t, 200, w.Code)
+	assert.Empty(t, receivePackage(t, f).Name)
+}
+
+func TestGiteaTag(t *testing.T) {
+	f := newFakeService()
+	s := newTestServer(f, ServerParam{})
+
+	w := postFixture(t, s, "/webhook/gitea?name=all/sample&version=^0.1", "gitea-create.json", map[string]string{"X-Gogs-Event": "create"})
+	assert.Equal(t, 200, w.Code)
+
+	pkg := receivePackage(t, f)
+	assert.Equal(t, "all/sample", pkg.Name)
+	assert.Equal(t, "^0.1", pkg.Version)
+}
+
+func TestGiteaIgnoredEvent(t *testing.T) {
+	f := newFakeService()
+	s := newTestServer(f, ServerParam{})
+
+	w := postFixture(t, s, "/webhook/gitea", "gitea-push.json", map[string]string{"X-Gitea-Event": "issues"})
+	assert.Equal(t, 200, w.Code)
+	assert.Len(t, f.packages, 0)
+}
+
+func TestGiteaSignature(t *testing.T) {
+	body, err := ioutil.ReadFile("fixtures/gitea-push.json")
+	assert.NoError(t, err)
+
+	mac := hmac.New(sha256.New, []byte("new-secret"))
+	mac.Write(body)
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	f := newFakeService()
+	s := newTestServer(f, ServerParam{GiteaSecrets: []string{"old-secret", "new-secret"}})
+
+	w := post(t, s, "/webhook/gitea", body, map[string]string{
+		"X-Gitea-Event":     "push",
+		"X-Gitea-Signature": signature,
+	})
+	assert.Equal(t, 200, w.Code)
+	receivePackage(t, f)
+
+	w = post(t, s, "/webhook/gitea", body, map[string]string{
+		"X-Gitea-Event":     "push",
+		"X-Gitea-Signature": "0123abcd",
+	})
+	assert.Equal(t, 401, w.Code)
+
+	w = post(t, s, "/webhook/gitea", body, map[string]string{"X-Gitea-Event": "push"})
+	assert.Equal(t, 401, w.Code)
+	assert.Len(t, f.packages, 0)
+}
@@ -1,7 +1,115 @@
 package api
 
-import "github.com/gin-gonic/gin"
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/reedom/satishub/pkg/satis"
+)
+
+type githubPayload struct {
+	Ref     string `json:"ref"`
+	RefType string `json:"ref_type"`
+	Deleted bool   `json:"deleted"`
+	Action  string `json:"action"`
+	Release struct {
+		TagName string `json:"tag_name"`
+	} `json:"release"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		CloneURL string `json:"clone_url"`
+		SSHURL   string `json:"ssh_url"`
+	} `json:"repository"`
+}
 
 func (s Server) handleGithub(ctx *gin.Context) {
-	ctx.JSON(500, "Not implemented")
+	body, err := ioutil.ReadAll(ctx.Request.Body)
+	if err != nil {
+		s.log.Println("failed to read GitHub WebHook content")
+		ctx.JSON(400, "Bad Request")
+		return
+	}
+
+	if 0 < len(s.githubSecrets) {
+		sig := ctx.GetHeader("X-Hub-Signature-256")
+		if !validHMACSignatureAny(s.githubSecrets, body, sig, "sha256=") {
+			s.log.Printf("AUDIT: rejected GitHub WebHook from %v: X-Hub-Signature-256 mismatch", ctx.ClientIP())
+			ctx.JSON(401, "Unauthorized")
+			return
+		}
+	}
+
+	event := ctx.GetHeader("X-GitHub-Event")
+	if event == "ping" {
+		ctx.JSON(200, "OK")
+		return
+	}
+
+	var req githubPayload
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		s.log.Println("GitHub WebHook content is broken?")
+		ctx.JSON(200, "OK")
+		return
+	}
+
+	if event == "repository" && req.Action == "deleted" {
+		if s.githubRepositoryDelete != PolicyRemove {
+			if s.debug {
+				s.log.Println("GitHub WebHook repository deleted event ignored")
+			}
+			ctx.JSON(200, "OK")
+			return
+		}
+		pkg := satis.PackageInfo{
+			Name: queryPackageName(ctx),
+			URL:  req.Repository.SSHURL,
+		}
+		if pkg.Name == "" {
+			pkg.Name = strings.ToLower(req.Repository.FullName)
+		}
+		s.removeRepository(ctx, pkg, s.githubSecrets)
+		return
+	}
+
+	if !githubShouldBuild(event, req) {
+		if s.debug {
+			s.log.Printf("GitHub WebHook event %q ignored", event)
+		}
+		ctx.JSON(200, "OK")
+		return
+	}
+
+	if req.Repository.SSHURL == "" {
+		if s.debug {
+			s.log.Println("repository URL not found in request payload")
+		}
+		ctx.JSON(200, "OK")
+		return
+	}
+
+	pkg := satis.PackageInfo{
+		Name:    queryPackageName(ctx),
+		Version: ctx.Query("version"),
+		URL:     req.Repository.SSHURL,
+		Type:    "vcs",
+	}
+	s.derivePackageName(&pkg, strings.ToLower(req.Repository.FullName))
+
+	s.queuePackage(ctx, pkg)
+}
+
+// githubShouldBuild determines whether the event affects the package repository.
+func githubShouldBuild(event string, req githubPayload) bool {
+	switch event {
+	case "push":
+		return !req.Deleted && (strings.HasPrefix(req.Ref, "refs/heads/") || strings.HasPrefix(req.Ref, "refs/tags/"))
+	case "create":
+		return req.RefType == "tag"
+	case "release":
+		return req.Action == "published" || req.Action == "created"
+	}
+	return false
 }
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGithubRepositoryDeleted(t *testing.T) {
+	body, err := ioutil.ReadFile("fixtures/github-repository-deleted.json")
+	assert.NoError(t, err)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	header := map[string]string{
+		"X-GitHub-Event":      "repository",
+		"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(mac.Sum(nil)),
+	}
+
+	f := newFakeService()
+	s := newTestServer(f, ServerParam{GithubSecrets: []string{"secret"}, GithubRepositoryDelete: PolicyRemove})
+	w := post(t, s, "/webhook/github", body, header)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"job_id":"remove-job"`)
+
+	pkg := receiveRemoval(t, f)
+	assert.Equal(t, "codertocat/hello-world", pkg.Name)
+	assert.Equal(t, "git@github.com:Codertocat/Hello-World.git", pkg.URL)
+	assert.Len(t, f.packages, 0)
+
+	// ignored by default
+	s = newTestServer(f, ServerParam{GithubSecrets: []string{"secret"}})
+	w = post(t, s, "/webhook/github", body, header)
+	assert.Equal(t, 200, w.Code)
+	assert.Len(t, f.removes, 0)
+
+	// refused without a secret
+	s = newTestServer(f, ServerParam{GithubRepositoryDelete: PolicyRemove})
+	w = postFixture(t, s, "/webhook/github", "github-repository-deleted.json", map[string]string{"X-GitHub-Event": "repository"})
+	assert.Equal(t, 403, w.Code)
+	assert.Len(t, f.removes, 0)
+}
+
+func TestGithubPush(t *testing.T) {
+	repo, cleanup := builtRepository(t, "codertocat/hello-world")
+	defer cleanup()
+	f := newFakeService()
+	f.repoPath = repo
+	s := newTestServer(f, ServerParam{})
+
+	w := postFixture(t, s, "/webhook/github", "github-push.json", map[string]string{"X-GitHub-Event": "push"})
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"job_id":"partial-job"}`, w.Body.String())
+	pkg := receivePackage(t, f)
+	assert.Equal(t, "codertocat/hello-world", pkg.Name)
+	assert.Equal(t, "git@github.com:Codertocat/Hello-World.git", pkg.URL)
+	assert.Equal(t, "vcs", pkg.Type)
+
+	w = postFixture(t, s, "/webhook/github?name=acme/hello&version=^1.0", "github-push.json", map[string]string{"X-GitHub-Event": "push"})
+	assert.Equal(t, 200, w.Code)
+	pkg = receivePackage(t, f)
+	assert.Equal(t, "acme/hello", pkg.Name)
+	assert.Equal(t, "^1.0", pkg.Version)
+
+	w = postFixture(t, s, "/webhook/github?name=--no-interaction", "github-push.json", map[string]string{"X-GitHub-Event": "push"})
+	assert.Equal(t, 400, w.Code)
+	assert.Len(t, f.packages, 0)
+
+	// a name not in the repository leads to a full rebuild
+	f.repoPath = ""
+	w = postFixture(t, s, "/webhook/github", "github-push.json", map[string]string{"X-GitHub-Event": "push"})
+	assert.Equal(t, 200, w.Code)
+	pkg = receivePackage(t, f)
+	assert.Empty(t, pkg.Name)
+	assert.Equal(t, "git@github.com:Codertocat/Hello-World.git", pkg.URL)
+}
+
+func TestGithubIgnoredEvent(t *testing.T) {
+	f := newFakeService()
+	s := newTestServer(f, ServerParam{})
+
+	body, err := ioutil.ReadFile("fixtures/github-push.json")
+	assert.NoError(t, err)
+	deleted := bytes.Replace(body, []byte(`"deleted": false`), []byte(`"deleted": true`), 1)
+	w := post(t, s, "/webhook/github", deleted, map[string]string{"X-GitHub-Event": "push"})
+	assert.Equal(t, 200, w.Code)
+	w = postFixture(t, s, "/webhook/github", "github-push.json", map[string]string{"X-GitHub-Event": "issues"})
+	assert.Equal(t, 200, w.Code)
+	w = postFixture(t, s, "/webhook/github", "github-push.json", map[string]string{"X-GitHub-Event": "ping"})
+	assert.Equal(t, 200, w.Code)
+	assert.Len(t, f.packages, 0)
+}
+
+func TestGithubSignature(t *testing.T) {
+	body, err := ioutil.ReadFile("fixtures/github-push.json")
+	assert.NoError(t, err)
+
+	mac := hmac.New(sha256.New, []byte("new-secret"))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	f := newFakeService()
+	s := newTestServer(f, ServerParam{GithubSecrets: []string{"old-secret", "new-secret"}})
+
+	w := post(t, s, "/webhook/github", body, map[string]string{
+		"X-GitHub-Event":      "push",
+		"X-Hub-Signature-256": signature,
+	})
+	assert.Equal(t, 200, w.Code)
+	receivePackage(t, f)
+
+	w = post(t, s, "/webhook/github", body, map[string]string{
+		"X-GitHub-Event":      "push",
+		"X-Hub-Signature-256": "sha256=0123abcd",
+	})
+	assert.Equal(t, 401, w.Code)
+
+	// the signature needs the "sha256=" prefix
+	w = post(t, s, "/webhook/github", body, map[string]string{
+		"X-GitHub-Event":      "push",
+		"X-Hub-Signature-256": strings.TrimPrefix(signature, "sha256="),
+	})
+	assert.Equal(t, 401, w.Code)
+
+	w = post(t, s, "/webhook/github", body, map[string]string{"X-GitHub-Event": "push"})
+	assert.Equal(t, 401, w.Code)
+	assert.Len(t, f.packages, 0)
+}
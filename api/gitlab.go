@@ -1,17 +1,93 @@
 package api
 
 import (
+	"strings"
+
 	"github.com/gin-gonic/gin"
 	"github.com/reedom/satishub/pkg/satis"
 )
 
+// GitLab event kinds which satishub can handle.
+const (
+	GitlabPush             = "push"
+	GitlabTagPush          = "tag_push"
+	GitlabRepositoryUpdate = "repository_update"
+	GitlabMergeRequest     = "merge_request"
+	GitlabProjectDestroy   = "project_destroy"
+)
+
+type gitlabPayload struct {
+	ObjectKind string `json:"object_kind"`
+	EventName  string `json:"event_name"`
+	// PathWithNamespace is given by the project system hooks.
+	PathWithNamespace string `json:"path_with_namespace"`
+	After             string `json:"after"`
+	CheckoutSHA       string `json:"checkout_sha"`
+	Project           struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		GitSSHURL         string `json:"git_ssh_url"`
+		GitHTTPURL        string `json:"git_http_url"`
+	} `json:"project"`
+	Repository struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	} `json:"repository"`
+	ObjectAttributes struct {
+		Action string `json:"action"`
+	} `json:"object_attributes"`
+}
+
+// kind returns the event kind. System hooks carry it in "event_name".
+func (p gitlabPayload) kind() string {
+	if p.ObjectKind != "" {
+		return p.ObjectKind
+	}
+	return p.EventName
+}
+
+// repositoryURL returns the repository URL to be registered to satis config.
+func (p gitlabPayload) repositoryURL() string {
+	if p.Repository.URL != "" {
+		return p.Repository.URL
+	}
+	return p.Project.GitSSHURL
+}
+
+// packageName derives the Composer package name from the project path.
+// It returns an empty string when the path can not be a package name.
+func (p gitlabPayload) packageName() string {
+	path := p.Project.PathWithNamespace
+	if path == "" {
+		path = p.PathWithNamespace
+	}
+	path = strings.ToLower(path)
+	if strings.Count(path, "/") != 1 {
+		return ""
+	}
+	return path
+}
+
+// affected determines whether the event changes the package contents.
+func (p gitlabPayload) affected() bool {
+	switch p.kind() {
+	case GitlabPush, GitlabTagPush:
+		// branch or tag removal
+		return p.CheckoutSHA != "" && strings.Trim(p.After, "0") != ""
+	case GitlabMergeRequest:
+		// the later updates of a merged merge request change nothing
+		return p.ObjectAttributes.Action == "merge"
+	}
+	return true
+}
+
 func (s Server) handleGitlab(ctx *gin.Context) {
-	var req struct {
-		Repository struct {
-			Name string `json:"name"`
-			URL  string `json:"url"`
-		}
+	if 0 < len(s.gitlabSecrets) && !validToken(s.gitlabSecrets, ctx.GetHeader("X-Gitlab-Token")) {
+		s.log.Printf("AUDIT: rejected GitLab WebHook from %v: X-Gitlab-Token mismatch", ctx.ClientIP())
+		ctx.JSON(401, "Unauthorized")
+		return
 	}
+
+	var req gitlabPayload
 	err := ctx.BindJSON(&req)
 	if err != nil {
 		s.log.Println("GitLab WebHook content is broken?")
@@ -19,7 +95,36 @@ func (s Server) handleGitlab(ctx *gin.Context) {
 		return
 	}
 
-	if req.Repository.URL == "" {
+	policy, ok := s.gitlabPolicies[req.kind()]
+	if !ok {
+		policy = PolicyIgnore
+	}
+	if policy == PolicyIgnore || !req.affected() {
+		if s.debug {
+			s.log.Printf("GitLab WebHook event %q ignored", req.kind())
+		}
+		ctx.JSON(200, "OK")
+		return
+	}
+
+	if policy == PolicyRemove {
+		pkg := satis.PackageInfo{Name: queryPackageName(ctx), URL: ctx.Query("url")}
+		if pkg.Name == "" {
+			pkg.Name = req.packageName()
+		}
+		if pkg.Name == "" {
+			if s.debug {
+				s.log.Println("package name not found in request payload")
+			}
+			ctx.JSON(200, "OK")
+			return
+		}
+		s.removeRepository(ctx, pkg, s.gitlabSecrets)
+		return
+	}
+
+	url := req.repositoryURL()
+	if url == "" {
 		if s.debug {
 			s.log.Println("repository URL not found in request payload")
 		}
@@ -28,18 +133,17 @@ func (s Server) handleGitlab(ctx *gin.Context) {
 	}
 
 	pkg := satis.PackageInfo{
-		Name:    ctx.Query("name"),
+		Name:    queryPackageName(ctx),
 		Version: ctx.Query("version"),
-		URL:     req.Repository.URL,
+		URL:     url,
 		Type:    "vcs",
 	}
+	s.derivePackageName(&pkg, req.packageName())
+	if policy == PolicyRebuild {
+		// an empty name leads to a full rebuild
+		pkg.Name = ""
+		pkg.Version = ""
+	}
 
-	go func() {
-		if s.debug {
-			s.log.Printf("process repository %v(%v)", pkg.Name, pkg.URL)
-		}
-		<-s.service.UpdatePackage(pkg)
-	}()
-
-	ctx.JSON(200, "OK")
+	s.queuePackage(ctx, pkg)
 }
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGitlabProjectDestroy(t *testing.T) {
+	f := newFakeService()
+	policies := map[string]EventPolicy{GitlabProjectDestroy: PolicyRemove}
+	s := newTestServer(f, ServerParam{GitlabSecrets: []string{"token"}, GitlabPolicies: policies})
+
+	token := map[string]string{"X-Gitlab-Token": "token"}
+	w := postFixture(t, s, "/webhook/gitlab", "gitlab-project-destroy.json", token)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"job_id":"remove-job"`)
+
+	pkg := receiveRemoval(t, f)
+	assert.Equal(t, "johnsmith/underscore", pkg.Name)
+	assert.Empty(t, pkg.URL)
+
+	// ignored by the policy
+	s = newTestServer(f, ServerParam{GitlabSecrets: []string{"token"}, GitlabPolicies: map[string]EventPolicy{GitlabProjectDestroy: PolicyIgnore}})
+	w = postFixture(t, s, "/webhook/gitlab", "gitlab-project-destroy.json", token)
+	assert.Equal(t, 200, w.Code)
+	assert.Len(t, f.removes, 0)
+
+	// refused without a secret
+	s = newTestServer(f, ServerParam{GitlabPolicies: policies})
+	w = postFixture(t, s, "/webhook/gitlab", "gitlab-project-destroy.json", nil)
+	assert.Equal(t, 403, w.Code)
+	assert.Len(t, f.removes, 0)
+}
+
+func TestGitlabToken(t *testing.T) {
+	f := newFakeService()
+	policies := map[string]EventPolicy{GitlabPush: PolicyBuild}
+	s := newTestServer(f, ServerParam{GitlabSecrets: []string{"old-token", "new-token"}, GitlabPolicies: policies})
+
+	w := postFixture(t, s, "/webhook/gitlab", "gitlab-webhook.json", map[string]string{"X-Gitlab-Token": "new-token"})
+	assert.Equal(t, 200, w.Code)
+	receivePackage(t, f)
+
+	w = postFixture(t, s, "/webhook/gitlab", "gitlab-webhook.json", map[string]string{"X-Gitlab-Token": "other-token"})
+	assert.Equal(t, 401, w.Code)
+	w = postFixture(t, s, "/webhook/gitlab", "gitlab-webhook.json", nil)
+	assert.Equal(t, 401, w.Code)
+	assert.Len(t, f.packages, 0)
+
+	// no secret configured accepts any token
+	s = newTestServer(f, ServerParam{GitlabPolicies: policies})
+	w = postFixture(t, s, "/webhook/gitlab", "gitlab-webhook.json", map[string]string{"X-Gitlab-Token": "any-token"})
+	assert.Equal(t, 200, w.Code)
+	receivePackage(t, f)
+}
+
+func TestGitlabPush(t *testing.T) {
+	repo, cleanup := builtRepository(t, "all/sample-repository", "jsmith/example")
+	defer cleanup()
+	f := newFakeService()
+	f.repoPath = repo
+	policies := map[string]EventPolicy{GitlabPush: PolicyBuild, GitlabTagPush: PolicyBuild}
+	s := newTestServer(f, ServerParam{GitlabPolicies: policies})
+
+	w := postFixture(t, s, "/webhook/gitlab", "gitlab-webhook.json", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"job_id":"partial-job"}`, w.Body.String())
+	pkg := receivePackage(t, f)
+	assert.Equal(t, "all/sample-repository", pkg.Name)
+	assert.Equal(t, "ssh://git@gitlab.example.com/all/sample-repository.git", pkg.URL)
+	assert.Equal(t, "vcs", pkg.Type)
+
+	w = postFixture(t, s, "/webhook/gitlab", "gitlab-tag-push.json", nil)
+	assert.Equal(t, 200, w.Code)
+	pkg = receivePackage(t, f)
+	assert.Equal(t, "jsmith/example", pkg.Name)
+	assert.Equal(t, "git@gitlab.example.com:jsmith/example.git", pkg.URL)
+
+	w = postFixture(t, s, "/webhook/gitlab?name=acme/sample&version=^0.1", "gitlab-webhook.json", nil)
+	assert.Equal(t, 200, w.Code)
+	pkg = receivePackage(t, f)
+	assert.Equal(t, "acme/sample", pkg.Name)
+	assert.Equal(t, "^0.1", pkg.Version)
+
+	// a project path different from the composer.json name leads to a full rebuild
+	f.repoPath = ""
+	w = postFixture(t, s, "/webhook/gitlab", "gitlab-webhook.json", nil)
+	assert.Equal(t, 200, w.Code)
+	pkg = receivePackage(t, f)
+	assert.Empty(t, pkg.Name)
+	assert.Equal(t, "ssh://git@gitlab.example.com/all/sample-repository.git", pkg.URL)
+
+	// a branch removal
+	body, err := ioutil.ReadFile("fixtures/gitlab-webhook.json")
+	assert.NoError(t, err)
+	body = bytes.Replace(body, []byte(`"after": "1dc3973a194c8dbc105480d33805223cb58d6d2d"`), []byte(`"after": "0000000000000000000000000000000000000000"`), 1)
+	body = bytes.Replace(body, []byte(`"checkout_sha": "1dc3973a194c8dbc105480d33805223cb58d6d2d"`), []byte(`"checkout_sha": null`), 1)
+	w = post(t, s, "/webhook/gitlab", body, nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Len(t, f.packages, 0)
+}
+
+func TestGitlabPolicies(t *testing.T) {
+	repo, cleanup := builtRepository(t, "all/sample-repository")
+	defer cleanup()
+	f := newFakeService()
+	f.repoPath = repo
+
+	s := newTestServer(f, ServerParam{GitlabPolicies: map[string]EventPolicy{GitlabPush: PolicyRebuild}})
+	w := postFixture(t, s, "/webhook/gitlab?version=^0.1", "gitlab-webhook.json", nil)
+	assert.Equal(t, 200, w.Code)
+	pkg := receivePackage(t, f)
+	assert.Empty(t, pkg.Name)
+	assert.Empty(t, pkg.Version)
+	assert.Equal(t, "ssh://git@gitlab.example.com/all/sample-repository.git", pkg.URL)
+
+	s = newTestServer(f, ServerParam{GitlabPolicies: map[string]EventPolicy{GitlabPush: PolicyIgnore}})
+	w = postFixture(t, s, "/webhook/gitlab", "gitlab-webhook.json", nil)
+	assert.Equal(t, 200, w.Code)
+
+	// the events without a policy are ignored
+	s = newTestServer(f, ServerParam{GitlabPolicies: map[string]EventPolicy{GitlabTagPush: PolicyBuild}})
+	w = postFixture(t, s, "/webhook/gitlab", "gitlab-webhook.json", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Len(t, f.packages, 0)
+}
+
+func TestGitlabMergeRequest(t *testing.T) {
+	repo, cleanup := builtRepository(t, "gitlabhq/gitlab-test")
+	defer cleanup()
+	f := newFakeService()
+	f.repoPath = repo
+	s := newTestServer(f, ServerParam{GitlabPolicies: map[string]EventPolicy{GitlabMergeRequest: PolicyBuild}})
+
+	w := postFixture(t, s, "/webhook/gitlab", "gitlab-merge-request.json", nil)
+	assert.Equal(t, 200, w.Code)
+	pkg := receivePackage(t, f)
+	assert.Equal(t, "gitlabhq/gitlab-test", pkg.Name)
+	assert.Equal(t, "git@gitlab.example.com:gitlabhq/gitlab-test.git", pkg.URL)
+
+	// the updates of the merged merge request are ignored
+	body, err := ioutil.ReadFile("fixtures/gitlab-merge-request.json")
+	assert.NoError(t, err)
+	for _, action := range []string{"update", "open", "close"} {
+		w = post(t, s, "/webhook/gitlab", bytes.Replace(body, []byte(`"action": "merge"`), []byte(`"action": "`+action+`"`), 1), nil)
+		assert.Equal(t, 200, w.Code)
+	}
+	assert.Len(t, f.packages, 0)
+}
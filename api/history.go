@@ -0,0 +1,107 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/reedom/satishub/pkg/satis"
+)
+
+// listRevisions handles GET /api/v1/config/revisions and responds with the
+// config revisions, the newest first.
+func (s Server) listRevisions(ctx *gin.Context) {
+	history := s.service.History()
+	if history == nil {
+		s.respondHistoryError(ctx, satis.ErrHistoryDisabled)
+		return
+	}
+	revs, err := history.Revisions()
+	if err != nil {
+		s.respondHistoryError(ctx, err)
+		return
+	}
+	if revs == nil {
+		revs = []satis.Revision{}
+	}
+	ctx.JSON(200, revs)
+}
+
+// getRevision handles GET /api/v1/config/revisions/{number} and responds
+// with the satis config of the revision.
+func (s Server) getRevision(ctx *gin.Context) {
+	number, err := strconv.Atoi(ctx.Param("number"))
+	if err != nil {
+		ctx.JSON(404, "Not Found")
+		return
+	}
+	history := s.service.History()
+	if history == nil {
+		s.respondHistoryError(ctx, satis.ErrHistoryDisabled)
+		return
+	}
+	_, data, err := history.Revision(number)
+	if err != nil {
+		s.respondHistoryError(ctx, err)
+		return
+	}
+	ctx.Data(200, "application/json; charset=utf-8", data)
+}
+
+// diffRevisions handles GET /api/v1/config/diff and responds with the
+// changes from the revision "from" to "to" in the unified format. Either
+// defaults to the latest revision.
+func (s Server) diffRevisions(ctx *gin.Context) {
+	var numbers [2]int
+	for i, key := range []string{"from", "to"} {
+		if value := ctx.Query(key); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				ctx.JSON(400, gin.H{"error": "invalid revision number: " + key})
+				return
+			}
+			numbers[i] = n
+		}
+	}
+	history := s.service.History()
+	if history == nil {
+		s.respondHistoryError(ctx, satis.ErrHistoryDisabled)
+		return
+	}
+	diff, err := history.Diff(numbers[0], numbers[1])
+	if err != nil {
+		s.respondHistoryError(ctx, err)
+		return
+	}
+	ctx.Data(200, "text/plain; charset=utf-8", []byte(diff))
+}
+
+// rollbackConfig handles POST /api/v1/config/revisions/{number}/rollback
+// which restores the satis config of the revision and rebuilds.
+func (s Server) rollbackConfig(ctx *gin.Context) {
+	number, err := strconv.Atoi(ctx.Param("number"))
+	if err != nil {
+		ctx.JSON(404, "Not Found")
+		return
+	}
+	jobID, ch, err := s.service.Rollback(number)
+	if err != nil {
+		s.respondHistoryError(ctx, err)
+		return
+	}
+	s.log.Printf("job %v: rollback to config revision %d requested from %v", jobID, number, ctx.ClientIP())
+	s.respondTrigger(ctx, jobID, ch)
+}
+
+// respondHistoryError responds with 404 when the history or the revision
+// is missing.
+func (s Server) respondHistoryError(ctx *gin.Context, err error) {
+	switch err {
+	case satis.ErrHistoryDisabled, satis.ErrRevisionNotFound:
+		ctx.JSON(404, gin.H{"error": err.Error()})
+	case satis.ErrQueueFull:
+		s.respondQueueError(ctx, err)
+	default:
+		s.log.Println("ERROR:", err.Error())
+		ctx.JSON(500, gin.H{"error": err.Error()})
+	}
+}
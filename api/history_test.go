@@ -0,0 +1,94 @@
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/reedom/satishub/pkg/satis"
+	"github.com/stretchr/testify/assert"
+)
+
+// newHistoryFakeService returns a fake service with a config history of
+// two revisions.
+func newHistoryFakeService(t *testing.T) (*fakeService, func()) {
+	dir, err := ioutil.TempDir("", "satis-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"revisions.jsonl": `{"number":1,"time":"2018-03-13T03:00:00Z","source":"startup"}
+{"number":2,"time":"2018-03-13T04:00:00Z","job_ids":["partial-job"],"source":"/webhook/gitlab"}
+`,
+		"1.json": "{\n  \"name\": \"a\"\n}\n",
+		"2.json": "{\n  \"name\": \"b\"\n}\n",
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := newFakeService()
+	f.history, err = satis.OpenConfigHistory(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, func() { os.RemoveAll(dir) }
+}
+
+func TestConfigHistoryAPI(t *testing.T) {
+	f, cleanup := newHistoryFakeService(t)
+	defer cleanup()
+	s := newTestServer(f, ServerParam{})
+
+	w := get(t, s, "/api/v1/config/revisions")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `[
+		{"number":2,"time":"2018-03-13T04:00:00Z","job_ids":["partial-job"],"source":"/webhook/gitlab"},
+		{"number":1,"time":"2018-03-13T03:00:00Z","source":"startup"}
+	]`, w.Body.String())
+
+	w = get(t, s, "/api/v1/config/revisions/1")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "{\n  \"name\": \"a\"\n}\n", w.Body.String())
+
+	w = get(t, s, "/api/v1/config/revisions/3")
+	assert.Equal(t, 404, w.Code)
+
+	w = get(t, s, "/api/v1/config/diff?from=1")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, `--- revision 1
++++ revision 2
+@@ -1,3 +1,3 @@
+ {
+-  "name": "a"
++  "name": "b"
+ }
+`, w.Body.String())
+
+	w = get(t, s, "/api/v1/config/diff?from=x")
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestConfigRollbackAPI(t *testing.T) {
+	f, cleanup := newHistoryFakeService(t)
+	defer cleanup()
+	s := newTestServer(f, ServerParam{})
+
+	w := send(t, s, "POST", "/api/v1/config/revisions/1/rollback", nil)
+	assert.Equal(t, 202, w.Code)
+	assert.JSONEq(t, `{"job_id":"rollback-job"}`, w.Body.String())
+	assert.Equal(t, 1, <-f.rollbacks)
+
+	w = send(t, s, "POST", "/api/v1/config/revisions/3/rollback", nil)
+	assert.Equal(t, 404, w.Code)
+
+	f.history = nil
+	w = send(t, s, "POST", "/api/v1/config/revisions/1/rollback", nil)
+	assert.Equal(t, 404, w.Code)
+	w = get(t, s, "/api/v1/config/revisions")
+	assert.Equal(t, 404, w.Code)
+	assert.Len(t, f.rollbacks, 0)
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/reedom/satishub/pkg/satis"
+)
+
+func (s Server) listJobs(ctx *gin.Context) {
+	ctx.JSON(200, s.service.Jobs())
+}
+
+func (s Server) getJob(ctx *gin.Context) {
+	job, ok := s.service.Job(ctx.Param("id"))
+	if !ok {
+		ctx.JSON(404, "Not Found")
+		return
+	}
+	ctx.JSON(200, job)
+}
+
+func (s Server) getJobLog(ctx *gin.Context) {
+	data, ok := s.service.JobLog(ctx.Param("id"))
+	if !ok {
+		ctx.JSON(404, "Not Found")
+		return
+	}
+	ctx.Data(200, "text/plain; charset=utf-8", data)
+}
+
+func (s Server) cancelJob(ctx *gin.Context) {
+	id := ctx.Param("id")
+	switch err := s.service.Cancel(id); err {
+	case nil:
+	case satis.ErrJobNotFound:
+		ctx.JSON(404, "Not Found")
+		return
+	case satis.ErrJobFinished:
+		ctx.JSON(409, gin.H{"error": err.Error()})
+		return
+	default:
+		ctx.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.log.Printf("job %v: cancel requested from %v", id, ctx.ClientIP())
+	job, _ := s.service.Job(id)
+	ctx.JSON(202, job)
+}
+
+func (s Server) listSchedules(ctx *gin.Context) {
+	ctx.JSON(200, s.service.Schedules())
+}
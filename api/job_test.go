@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/reedom/satishub/pkg/satis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobAPI(t *testing.T) {
+	s := newTestServer(newFakeService(), ServerParam{})
+
+	w := get(t, s, "/api/v1/jobs")
+	assert.Equal(t, 200, w.Code)
+	var jobs []satis.Job
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &jobs))
+	assert.Len(t, jobs, 1)
+
+	w = get(t, s, "/api/v1/jobs/partial-job")
+	assert.Equal(t, 200, w.Code)
+	var job satis.Job
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &job))
+	assert.Equal(t, satis.JobSucceeded, job.State)
+
+	w = get(t, s, "/api/v1/jobs/unknown")
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestJobLogAPI(t *testing.T) {
+	s := newTestServer(newFakeService(), ServerParam{})
+
+	w := get(t, s, "/api/v1/jobs/partial-job/log")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "build satis.json repo\n", w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+
+	w = get(t, s, "/api/v1/jobs/unknown/log")
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestJobEventsAPI(t *testing.T) {
+	s := newTestServer(newFakeService(), ServerParam{})
+
+	// the stream ends immediately since the job has finished
+	w := get(t, s, "/api/v1/jobs/partial-job/events")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/event-stream")
+	assert.Contains(t, w.Body.String(), "event:state\n")
+	assert.Contains(t, w.Body.String(), `"state":"succeeded"`)
+	assert.Contains(t, w.Body.String(), "event:output\ndata:build satis.json repo\n")
+
+	w = get(t, s, "/api/v1/jobs/unknown/events")
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestJobEventsAPILostState(t *testing.T) {
+	keepAlive := sseKeepAlive
+	sseKeepAlive = 10 * time.Millisecond
+	defer func() { sseKeepAlive = keepAlive }()
+
+	f := newFakeService()
+	f.jobs.Store([]satis.Job{{ID: "partial-job", Kind: satis.JobKindPartial, State: satis.JobRunning}})
+	s := newTestServer(f, ServerParam{})
+
+	// the fake never sends the finished state event, which the stream finds
+	// on a keep-alive
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		f.jobs.Store([]satis.Job{{ID: "partial-job", Kind: satis.JobKindPartial, State: satis.JobFailed}})
+	}()
+	req, err := http.NewRequest("GET", "/api/v1/jobs/partial-job/events", nil)
+	assert.NoError(t, err)
+	w := closeNotifyRecorder{httptest.NewRecorder()}
+	s.setupHandler().ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"state":"running"`)
+	assert.Contains(t, w.Body.String(), "event:ping\n")
+	assert.Contains(t, w.Body.String(), `"state":"failed"`)
+}
+
+// closeNotifyRecorder is a recorder for the streams, which need
+// http.CloseNotifier.
+type closeNotifyRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (closeNotifyRecorder) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func TestCancelJobAPI(t *testing.T) {
+	s := newTestServer(newFakeService(), ServerParam{})
+
+	w := request(t, s, "DELETE", "/api/v1/jobs/queued-job")
+	assert.Equal(t, 202, w.Code)
+
+	w = request(t, s, "DELETE", "/api/v1/jobs/partial-job")
+	assert.Equal(t, 409, w.Code)
+
+	w = request(t, s, "DELETE", "/api/v1/jobs/unknown")
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestListSchedulesAPI(t *testing.T) {
+	s := newTestServer(newFakeService(), ServerParam{})
+
+	w := get(t, s, "/api/v1/schedules")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"spec":"0 3 * * *"`)
+	assert.Contains(t, w.Body.String(), `"next_run":"2018-03-13T03:00:00Z"`)
+}
@@ -0,0 +1,27 @@
+package api
+
+import "github.com/pkg/errors"
+
+// EventPolicy determines how satishub responds to a WebHook event.
+type EventPolicy string
+
+const (
+	// PolicyBuild updates the satis config and builds the package only.
+	PolicyBuild EventPolicy = "build"
+	// PolicyIgnore does nothing.
+	PolicyIgnore EventPolicy = "ignore"
+	// PolicyRebuild updates the satis config and rebuilds the whole repository.
+	PolicyRebuild EventPolicy = "rebuild"
+	// PolicyRemove removes the package from the satis config and the repository.
+	// It is only for the repository deletion events.
+	PolicyRemove EventPolicy = "remove"
+)
+
+// ParseEventPolicy converts a string into EventPolicy.
+func ParseEventPolicy(value string) (EventPolicy, error) {
+	switch p := EventPolicy(value); p {
+	case PolicyBuild, PolicyIgnore, PolicyRebuild, PolicyRemove:
+		return p, nil
+	}
+	return "", errors.Errorf("unknown event policy %q (build, ignore, rebuild or remove)", value)
+}
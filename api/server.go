@@ -14,17 +14,66 @@ import (
 
 // Server manages the web servers for staishub services.
 type Server struct {
-	service satis.Service
-	log     *log.Logger
-	debug   bool
+	service       satis.Service
+	log           *log.Logger
+	debug         bool
+	gitlabSecrets []string
+	githubSecrets []string
+
+	gitlabPolicies         map[string]EventPolicy
+	githubRepositoryDelete EventPolicy
+
+	bitbucketSecrets []string
+	bitbucketClone   string
+
+	giteaSecrets []string
+
+	apiTokens []string
+}
+
+// ServerParam contains parameters to NewServer() call.
+type ServerParam struct {
+	Service satis.Service
+	Log     *log.Logger
+	Debug   bool
+	// GitlabSecrets lists accepted X-Gitlab-Token values. Empty means no check.
+	GitlabSecrets []string
+	// GithubSecrets lists accepted GitHub WebHook secrets. Empty means no check.
+	GithubSecrets []string
+	// GitlabPolicies maps GitLab event kinds to their policies.
+	// Events not in the map are ignored.
+	GitlabPolicies map[string]EventPolicy
+	// GithubRepositoryDelete is the policy on GitHub repository deleted
+	// events, PolicyRemove or PolicyIgnore. Empty means PolicyIgnore.
+	GithubRepositoryDelete EventPolicy
+	// BitbucketSecrets lists accepted Bitbucket WebHook secrets. Empty means no check.
+	BitbucketSecrets []string
+	// BitbucketClone specifies the repository URL protocol, "ssh" or "https".
+	BitbucketClone string
+	// GiteaSecrets lists accepted Gitea, Forgejo or Gogs WebHook secrets. Empty means no check.
+	GiteaSecrets []string
+	// APITokens lists accepted bearer tokens for /api/v1 endpoints. Empty means no check.
+	APITokens []string
 }
 
 // NewServer creates Server.
-func NewServer(service satis.Service, logger *log.Logger, debug bool) Server {
+func NewServer(param ServerParam) Server {
 	return Server{
-		service: service,
-		log:     logger,
-		debug:   debug,
+		service:       param.Service,
+		log:           param.Log,
+		debug:         param.Debug,
+		gitlabSecrets: param.GitlabSecrets,
+		githubSecrets: param.GithubSecrets,
+
+		gitlabPolicies:         param.GitlabPolicies,
+		githubRepositoryDelete: param.GithubRepositoryDelete,
+
+		bitbucketSecrets: param.BitbucketSecrets,
+		bitbucketClone:   param.BitbucketClone,
+
+		giteaSecrets: param.GiteaSecrets,
+
+		apiTokens: param.APITokens,
 	}
 }
 
@@ -81,9 +130,53 @@ func (s Server) setupHandler() *gin.Engine {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	if len(s.apiTokens) == 0 {
+		s.log.Println("WARNING: /api/v1 accepts requests without authentication; set api-token to protect it")
+	}
+
 	r := gin.Default()
 	r.POST("/webhook/gitlab", s.handleGitlab)
+	r.POST("/webhook/github", s.handleGithub)
+	r.POST("/webhook/bitbucket", s.handleBitbucket)
+	r.POST("/webhook/gitea", s.handleGitea)
 	r.GET("/config", s.readConfig)
+
+	v1 := r.Group("/api/v1", s.authorize)
+	v1.POST("/packages/update", s.updatePackage)
+	v1.DELETE("/packages/:vendor/:name", s.removePackage)
+	v1.POST("/rebuild", s.rebuild)
+	v1.GET("/jobs", s.listJobs)
+	v1.GET("/jobs/:id", s.getJob)
+	v1.DELETE("/jobs/:id", s.cancelJob)
+	v1.GET("/jobs/:id/log", s.getJobLog)
+	v1.GET("/jobs/:id/events", s.streamJobEvents)
+	v1.GET("/events", s.streamAllJobEvents)
+	v1.GET("/schedules", s.listSchedules)
+	v1.GET("/config", s.getConfig)
+	v1.PATCH("/config", s.patchConfig)
+	v1.GET("/config/repositories", s.listRepositories)
+	v1.POST("/config/repositories", s.addRepository)
+	v1.GET("/config/repositories/:id", s.getRepository)
+	v1.PATCH("/config/repositories/:id", s.patchRepository)
+	v1.DELETE("/config/repositories/:id", s.deleteRepository)
+	v1.GET("/config/require", s.listRequires)
+	v1.PUT("/config/require/:vendor/:name", s.setRequire)
+	v1.DELETE("/config/require/:vendor/:name", s.deleteRequire)
+	v1.GET("/config/revisions", s.listRevisions)
+	v1.GET("/config/revisions/:number", s.getRevision)
+	v1.POST("/config/revisions/:number/rollback", s.rollbackConfig)
+	v1.GET("/config/diff", s.diffRevisions)
+	v1.GET("/generations", s.listGenerations)
+	v1.POST("/generations/:id/switch", s.switchGeneration)
+
+	if s.service.Index() != nil {
+		r.GET("/packages.json", s.packagesJSON)
+		r.HEAD("/packages.json", s.packagesJSON)
+		r.GET("/p2/:vendor/:file", s.metadata)
+		r.HEAD("/p2/:vendor/:file", s.metadata)
+		r.GET("/providers/:vendor/:file", s.providers)
+		r.HEAD("/providers/:vendor/:file", s.providers)
+	}
 	r.StaticFile("/", path.Join(s.service.RepoPath(), "index.html"))
 	r.Use(static.Serve("/", static.LocalFile(s.service.RepoPath(), false)))
 	return r
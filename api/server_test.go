@@ -0,0 +1,273 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/reedom/satishub/pkg/satis"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeService records the requests instead of running satis.
+type fakeService struct {
+	packages chan satis.PackageInfo
+	rebuilds chan struct{}
+	removes  chan satis.PackageInfo
+	full     bool
+	// configPath is the satis config for the config APIs.
+	configPath string
+	history    *satis.ConfigHistory
+	rollbacks  chan int
+	// generations are the output generations; nil means disabled.
+	generations []satis.Generation
+	switches    chan string
+	index       *satis.ComposerIndex
+	repoPath    string
+	// jobs replaces the job list when it holds a []satis.Job.
+	jobs atomic.Value
+}
+
+func newFakeService() *fakeService {
+	return &fakeService{
+		packages:  make(chan satis.PackageInfo, 16),
+		rebuilds:  make(chan struct{}, 16),
+		removes:   make(chan satis.PackageInfo, 16),
+		rollbacks: make(chan int, 16),
+		switches:  make(chan string, 16),
+	}
+}
+
+func (f *fakeService) Close() {}
+
+func (f *fakeService) Run(ctx context.Context) <-chan satis.ServiceResult {
+	return make(chan satis.ServiceResult)
+}
+
+func (f *fakeService) Rebuild() (string, chan satis.ServiceResult, error) {
+	if f.full {
+		return "", nil, satis.ErrQueueFull
+	}
+	f.rebuilds <- struct{}{}
+	return "rebuild-job", f.done(), nil
+}
+
+func (f *fakeService) UpdatePackage(pkg satis.PackageInfo) (string, chan satis.ServiceResult, error) {
+	if f.full {
+		return "", nil, satis.ErrQueueFull
+	}
+	f.packages <- pkg
+	return "partial-job", f.done(), nil
+}
+
+func (f *fakeService) RemovePackage(pkg satis.PackageInfo) (string, chan satis.ServiceResult, error) {
+	if f.full {
+		return "", nil, satis.ErrQueueFull
+	}
+	f.removes <- pkg
+	return "remove-job", f.done(), nil
+}
+
+func (f *fakeService) Rollback(revision int) (string, chan satis.ServiceResult, error) {
+	if f.history == nil {
+		return "", nil, satis.ErrHistoryDisabled
+	}
+	if _, _, err := f.history.Revision(revision); err != nil {
+		return "", nil, err
+	}
+	f.rollbacks <- revision
+	return "rollback-job", f.done(), nil
+}
+
+func (f *fakeService) EditConfig(source string, fn func(configPath string) error) error {
+	return fn(f.ConfigPath())
+}
+
+func (f *fakeService) History() *satis.ConfigHistory {
+	return f.history
+}
+
+func (f *fakeService) Generations() ([]satis.Generation, error) {
+	if f.generations == nil {
+		return nil, satis.ErrGenerationsDisabled
+	}
+	return f.generations, nil
+}
+
+func (f *fakeService) SwitchGeneration(id string) (string, chan satis.ServiceResult, error) {
+	if f.generations == nil {
+		return "", nil, satis.ErrGenerationsDisabled
+	}
+	for _, gen := range f.generations {
+		if gen.ID == id {
+			f.switches <- id
+			return "switch-job", f.done(), nil
+		}
+	}
+	return "", nil, satis.ErrGenerationNotFound
+}
+
+func (f *fakeService) Index() *satis.ComposerIndex {
+	return f.index
+}
+
+func (f *fakeService) Cancel(id string) error {
+	switch id {
+	case "queued-job":
+		return nil
+	case "partial-job":
+		return satis.ErrJobFinished
+	}
+	return satis.ErrJobNotFound
+}
+
+func (f *fakeService) Jobs() []satis.Job {
+	if jobs, ok := f.jobs.Load().([]satis.Job); ok {
+		return jobs
+	}
+	return []satis.Job{{ID: "partial-job", Kind: satis.JobKindPartial, State: satis.JobSucceeded}}
+}
+
+func (f *fakeService) Job(id string) (satis.Job, bool) {
+	for _, job := range f.Jobs() {
+		if job.ID == id {
+			return job, true
+		}
+	}
+	return satis.Job{}, false
+}
+
+func (f *fakeService) done() chan satis.ServiceResult {
+	ch := make(chan satis.ServiceResult, 1)
+	ch <- satis.ServiceResult{}
+	close(ch)
+	return ch
+}
+
+func (f *fakeService) JobLog(id string) ([]byte, bool) {
+	if _, ok := f.Job(id); !ok {
+		return nil, false
+	}
+	return []byte("build satis.json repo\n"), true
+}
+
+func (f *fakeService) Schedules() []satis.ScheduleStatus {
+	next := time.Date(2018, 3, 13, 3, 0, 0, 0, time.UTC)
+	return []satis.ScheduleStatus{
+		{Schedule: satis.Schedule{Spec: "0 3 * * *"}, NextRun: &next},
+	}
+}
+
+func (f *fakeService) Subscribe(id string) (satis.JobSubscription, bool) {
+	var sub satis.JobSubscription
+	if id != "" {
+		job, ok := f.Job(id)
+		if !ok {
+			return sub, false
+		}
+		sub.Job = job
+		sub.Output, _ = f.JobLog(id)
+	}
+	ch := make(chan satis.JobEvent)
+	sub.Events = ch
+	sub.Close = func() {}
+	return sub, true
+}
+
+func (f *fakeService) ConfigPath() string {
+	if f.configPath != "" {
+		return f.configPath
+	}
+	return "satis.json"
+}
+
+func (f *fakeService) RepoPath() string {
+	if f.repoPath != "" {
+		return f.repoPath
+	}
+	return "repo"
+}
+
+func newTestServer(service satis.Service, param ServerParam) Server {
+	param.Service = service
+	param.Log = log.New(ioutil.Discard, "", 0)
+	return NewServer(param)
+}
+
+// postFixture posts the fixture file content to the server.
+func postFixture(t *testing.T, s Server, path, fixture string, header map[string]string) *httptest.ResponseRecorder {
+	body, err := ioutil.ReadFile("fixtures/" + fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return post(t, s, path, body, header)
+}
+
+func post(t *testing.T, s Server, path string, body []byte, header map[string]string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", path, bytes.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	w := httptest.NewRecorder()
+	s.setupHandler().ServeHTTP(w, req)
+	return w
+}
+
+// builtRepository returns a satis output directory which has the packages
+// built, for the WebHook handlers to find the names they derive.
+func builtRepository(t *testing.T, names ...string) (string, func()) {
+	dir, err := ioutil.TempDir("", "satis-repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		path := filepath.Join(dir, "p2", filepath.FromSlash(name)+".json")
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path, []byte(`{"packages":{}}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// receiveRemoval returns the package removal the fake service received.
+func receiveRemoval(t *testing.T, f *fakeService) satis.PackageInfo {
+	select {
+	case pkg := <-f.removes:
+		return pkg
+	default:
+		t.Fatal("no package removal requested")
+		return satis.PackageInfo{}
+	}
+}
+
+func get(t *testing.T, s Server, path string) *httptest.ResponseRecorder {
+	return request(t, s, "GET", path)
+}
+
+func request(t *testing.T, s Server, method, path string) *httptest.ResponseRecorder {
+	return send(t, s, method, path, nil)
+}
+
+func send(t *testing.T, s Server, method, path string, body []byte) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, bytes.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	s.setupHandler().ServeHTTP(w, req)
+	return w
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+)
+
+// validHMACSignature reports whether signature is a hex encoded HMAC-SHA256
+// digest of body keyed with secret. prefix, such as "sha256=", is stripped
+// from signature beforehand.
+func validHMACSignature(secret string, body []byte, signature, prefix string) bool {
+	if !strings.HasPrefix(signature, prefix) {
+		return false
+	}
+	actual, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(actual, mac.Sum(nil))
+}
+
+// validHMACSignatureAny reports whether signature matches any of secrets.
+func validHMACSignatureAny(secrets []string, body []byte, signature, prefix string) bool {
+	for _, secret := range secrets {
+		if validHMACSignature(secret, body, signature, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// validToken reports whether token equals to any of secrets.
+// The comparison takes constant time regardless of where they differ.
+func validToken(secrets []string, token string) bool {
+	valid := false
+	for _, secret := range secrets {
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(token)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
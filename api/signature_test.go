@@ -0,0 +1,37 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidHMACSignature(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/master"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	digest := hex.EncodeToString(mac.Sum(nil))
+
+	assert.True(t, validHMACSignature("secret", body, "sha256="+digest, "sha256="))
+	assert.True(t, validHMACSignature("secret", body, digest, ""))
+	assert.False(t, validHMACSignature("secret", body, digest, "sha256="))
+	assert.False(t, validHMACSignature("other", body, "sha256="+digest, "sha256="))
+	assert.False(t, validHMACSignature("secret", []byte(`{}`), "sha256="+digest, "sha256="))
+	assert.False(t, validHMACSignature("secret", body, "sha256=not-hex", "sha256="))
+	assert.False(t, validHMACSignature("secret", body, "", "sha256="))
+
+	assert.True(t, validHMACSignatureAny([]string{"old", "secret"}, body, digest, ""))
+	assert.False(t, validHMACSignatureAny(nil, body, digest, ""))
+}
+
+func TestValidToken(t *testing.T) {
+	assert.True(t, validToken([]string{"secret"}, "secret"))
+	assert.True(t, validToken([]string{"old", "secret"}, "secret"))
+	assert.False(t, validToken([]string{"secret"}, "secreT"))
+	assert.False(t, validToken([]string{"secret"}, "secret2"))
+	assert.False(t, validToken([]string{"secret"}, ""))
+	assert.False(t, validToken(nil, "secret"))
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/reedom/satishub/pkg/satis"
+)
+
+// updatePackageRequest is the request body of POST /api/v1/packages/update.
+//
+//	{
+//	  "name": "vendor/package",        // optional. full rebuild if omitted
+//	  "version": "^1.0",               // optional. added to "require" if specified
+//	  "url": "git@example.com:vendor/package.git",
+//	  "type": "vcs"                    // optional. "vcs" by default
+//	}
+type updatePackageRequest struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	URL     string `json:"url" binding:"required"`
+	Type    string `json:"type"`
+}
+
+// triggerResponse is the response body of trigger APIs.
+type triggerResponse struct {
+	JobID  string          `json:"job_id"`
+	Result *resultResponse `json:"result,omitempty"`
+}
+
+type resultResponse struct {
+	Succeeded bool   `json:"succeeded"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (s Server) updatePackage(ctx *gin.Context) {
+	var req updatePackageRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	pkg := satis.PackageInfo{
+		Name:    strings.ToLower(req.Name),
+		Version: req.Version,
+		URL:     req.URL,
+		Type:    req.Type,
+		Source:  ctx.Request.URL.Path,
+	}
+	if pkg.Name != "" && !satis.ValidPackageName(pkg.Name) {
+		ctx.JSON(400, gin.H{"error": satis.ErrInvalidPackageName.Error()})
+		return
+	}
+	if pkg.Type == "" {
+		pkg.Type = "vcs"
+	}
+	if pkg.Name == "" {
+		pkg.Version = ""
+	}
+
+	jobID, ch, err := s.service.UpdatePackage(pkg)
+	if err != nil {
+		s.respondQueueError(ctx, err)
+		return
+	}
+	if s.debug {
+		s.log.Printf("job %v: process repository %v(%v)", jobID, pkg.Name, pkg.URL)
+	}
+	s.respondTrigger(ctx, jobID, ch)
+}
+
+func (s Server) rebuild(ctx *gin.Context) {
+	jobID, ch, err := s.service.Rebuild()
+	if err != nil {
+		s.respondQueueError(ctx, err)
+		return
+	}
+	if s.debug {
+		s.log.Printf("job %v: rebuild", jobID)
+	}
+	s.respondTrigger(ctx, jobID, ch)
+}
+
+// removePackage handles DELETE /api/v1/packages/{vendor}/{name}. The "url"
+// query parameter specifies the repository when its URL does not end with
+// the package name.
+func (s Server) removePackage(ctx *gin.Context) {
+	pkg := satis.PackageInfo{
+		Name:   strings.ToLower(ctx.Param("vendor") + "/" + ctx.Param("name")),
+		URL:    ctx.Query("url"),
+		Source: ctx.Request.URL.Path,
+	}
+	if !satis.ValidPackageName(pkg.Name) {
+		ctx.JSON(400, gin.H{"error": satis.ErrInvalidPackageName.Error()})
+		return
+	}
+
+	jobID, ch, err := s.service.RemovePackage(pkg)
+	if err != nil {
+		s.respondQueueError(ctx, err)
+		return
+	}
+	if s.debug {
+		s.log.Printf("job %v: remove package %v", jobID, pkg.Name)
+	}
+	s.respondTrigger(ctx, jobID, ch)
+}
+
+// respondTrigger responds with the job ID. When the request has "wait=true"
+// query parameter, it waits for the service result and responds with it as well.
+func (s Server) respondTrigger(ctx *gin.Context, jobID string, ch chan satis.ServiceResult) {
+	if ctx.Query("wait") != "true" {
+		ctx.JSON(202, triggerResponse{JobID: jobID})
+		return
+	}
+
+	select {
+	case <-ctx.Request.Context().Done():
+		return
+	case r, ok := <-ch:
+		res := resultResponse{Succeeded: ok && r.Succeeded()}
+		if !ok {
+			res.Error = "request discarded"
+		} else if r.Error != nil {
+			res.Error = r.Error.Error()
+		}
+		ctx.JSON(200, triggerResponse{JobID: jobID, Result: &res})
+	}
+}
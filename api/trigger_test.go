@@ -0,0 +1,123 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdatePackageAPI(t *testing.T) {
+	f := newFakeService()
+	s := newTestServer(f, ServerParam{})
+
+	body := []byte(`{"name":"vendor/pkg","version":"^1.0","url":"git@example.com:vendor/pkg.git"}`)
+	w := post(t, s, "/api/v1/packages/update", body, nil)
+	assert.Equal(t, 202, w.Code)
+
+	var res triggerResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.NotEmpty(t, res.JobID)
+	assert.Nil(t, res.Result)
+
+	pkg := receivePackage(t, f)
+	assert.Equal(t, "vendor/pkg", pkg.Name)
+	assert.Equal(t, "^1.0", pkg.Version)
+	assert.Equal(t, "vcs", pkg.Type)
+
+	// a name is never passed to satis as an option
+	body = []byte(`{"name":"--no-interaction","url":"git@example.com:vendor/pkg.git"}`)
+	w = post(t, s, "/api/v1/packages/update", body, nil)
+	assert.Equal(t, 400, w.Code)
+	assert.Len(t, f.packages, 0)
+}
+
+func TestUpdatePackageAPIWait(t *testing.T) {
+	f := newFakeService()
+	s := newTestServer(f, ServerParam{})
+
+	body := []byte(`{"url":"git@example.com:vendor/pkg.git","type":"git"}`)
+	w := post(t, s, "/api/v1/packages/update?wait=true", body, nil)
+	assert.Equal(t, 200, w.Code)
+
+	var res triggerResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.NotEmpty(t, res.JobID)
+	if assert.NotNil(t, res.Result) {
+		assert.True(t, res.Result.Succeeded)
+	}
+}
+
+func TestUpdatePackageAPIInvalidBody(t *testing.T) {
+	f := newFakeService()
+	s := newTestServer(f, ServerParam{})
+
+	w := post(t, s, "/api/v1/packages/update", []byte(`{"name":"vendor/pkg"}`), nil)
+	assert.Equal(t, 400, w.Code)
+	assert.Len(t, f.packages, 0)
+}
+
+func TestRebuildAPIToken(t *testing.T) {
+	f := newFakeService()
+	s := newTestServer(f, ServerParam{APITokens: []string{"token"}})
+
+	w := post(t, s, "/api/v1/rebuild", nil, nil)
+	assert.Equal(t, 401, w.Code)
+	assert.Len(t, f.rebuilds, 0)
+
+	// the scheme is required
+	w = post(t, s, "/api/v1/rebuild", nil, map[string]string{"Authorization": "token"})
+	assert.Equal(t, 401, w.Code)
+	w = post(t, s, "/api/v1/rebuild", nil, map[string]string{"Authorization": "Basic token"})
+	assert.Equal(t, 401, w.Code)
+	w = post(t, s, "/api/v1/rebuild", nil, map[string]string{"Authorization": "Bearer other"})
+	assert.Equal(t, 401, w.Code)
+	assert.Len(t, f.rebuilds, 0)
+
+	w = post(t, s, "/api/v1/rebuild", nil, map[string]string{"Authorization": "Bearer token"})
+	assert.Equal(t, 202, w.Code)
+	w = post(t, s, "/api/v1/rebuild", nil, map[string]string{"Authorization": "bearer token"})
+	assert.Equal(t, 202, w.Code)
+	assert.Len(t, f.rebuilds, 2)
+}
+
+func TestUnauthenticatedAPIWarning(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewServer(ServerParam{Service: newFakeService(), Log: log.New(&buf, "", 0)})
+	s.setupHandler()
+	assert.Contains(t, buf.String(), "WARNING: /api/v1 accepts requests without authentication")
+
+	buf.Reset()
+	s = NewServer(ServerParam{Service: newFakeService(), Log: log.New(&buf, "", 0), APITokens: []string{"token"}})
+	s.setupHandler()
+	assert.NotContains(t, buf.String(), "WARNING")
+}
+
+func TestRebuildAPIQueueFull(t *testing.T) {
+	f := newFakeService()
+	f.full = true
+	s := newTestServer(f, ServerParam{})
+
+	w := post(t, s, "/api/v1/rebuild", nil, nil)
+	assert.Equal(t, 503, w.Code)
+	assert.Contains(t, w.Body.String(), "queue full")
+}
+
+func TestRemovePackageAPI(t *testing.T) {
+	f := newFakeService()
+	s := newTestServer(f, ServerParam{})
+
+	w := request(t, s, "DELETE", "/api/v1/packages/Vendor/pkg?url=git@example.com:vendor/pkg.git")
+	assert.Equal(t, 202, w.Code)
+	assert.Contains(t, w.Body.String(), `"job_id":"remove-job"`)
+
+	pkg := receiveRemoval(t, f)
+	assert.Equal(t, "vendor/pkg", pkg.Name)
+	assert.Equal(t, "git@example.com:vendor/pkg.git", pkg.URL)
+
+	w = request(t, s, "DELETE", "/api/v1/packages/vendor/..")
+	assert.Equal(t, 400, w.Code)
+	assert.Len(t, f.removes, 0)
+}
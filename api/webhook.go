@@ -0,0 +1,84 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/reedom/satishub/pkg/satis"
+)
+
+// queuePackage requests the package update and responds with the job ID.
+func (s Server) queuePackage(ctx *gin.Context, pkg satis.PackageInfo) {
+	if pkg.Name != "" && !satis.ValidPackageName(pkg.Name) {
+		ctx.JSON(400, gin.H{"error": satis.ErrInvalidPackageName.Error()})
+		return
+	}
+	pkg.Source = ctx.Request.URL.Path
+	jobID, _, err := s.service.UpdatePackage(pkg)
+	if err != nil {
+		s.respondQueueError(ctx, err)
+		return
+	}
+	if s.debug {
+		s.log.Printf("job %v: process repository %v(%v)", jobID, pkg.Name, pkg.URL)
+	}
+	ctx.JSON(200, gin.H{"job_id": jobID})
+}
+
+// derivePackageName sets the package name derived from the WebHook payload,
+// unless "?name=" has given one. A derived name which the built repository
+// does not have, such as a project path different from the composer.json
+// name, is left empty so that a full rebuild picks up the package.
+func (s Server) derivePackageName(pkg *satis.PackageInfo, name string) {
+	if pkg.Name != "" || name == "" {
+		return
+	}
+	if satis.HasPackage(s.service.RepoPath(), name) {
+		pkg.Name = name
+	} else if s.debug {
+		s.log.Printf("package %v not found in the repository, rebuilding all", name)
+	}
+}
+
+// queryPackageName returns the package name given by the "name" query
+// parameter, lowercased as Composer does. The service rejects an invalid one.
+func queryPackageName(ctx *gin.Context) string {
+	return strings.ToLower(ctx.Query("name"))
+}
+
+// removeRepository requests removing the package of a deleted repository
+// and responds with the job ID. The removal is refused unless the WebHook
+// is verified by secrets, so that anyone cannot remove packages.
+func (s Server) removeRepository(ctx *gin.Context, pkg satis.PackageInfo, secrets []string) {
+	if !satis.ValidPackageName(pkg.Name) {
+		ctx.JSON(400, gin.H{"error": satis.ErrInvalidPackageName.Error()})
+		return
+	}
+	if len(secrets) == 0 {
+		s.log.Printf("AUDIT: refused to remove %v requested from %v: no WebHook secret configured", pkg.Name, ctx.ClientIP())
+		ctx.JSON(403, gin.H{"error": "removal requires a WebHook secret"})
+		return
+	}
+
+	pkg.Source = ctx.Request.URL.Path
+	jobID, _, err := s.service.RemovePackage(pkg)
+	if err != nil {
+		s.respondQueueError(ctx, err)
+		return
+	}
+	if s.debug {
+		s.log.Printf("job %v: remove repository %v(%v)", jobID, pkg.Name, pkg.URL)
+	}
+	ctx.JSON(200, gin.H{"job_id": jobID})
+}
+
+// respondQueueError responds with 503 when the service could not queue the
+// request, or with 400 when the request is invalid.
+func (s Server) respondQueueError(ctx *gin.Context, err error) {
+	s.log.Println("ERROR:", err.Error())
+	if err == satis.ErrInvalidPackageName {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(503, gin.H{"error": err.Error()})
+}
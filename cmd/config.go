@@ -0,0 +1,216 @@
+// Copyright © 2018 HANAI Tohru <tohru@reedom.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/reedom/satishub/pkg/satis"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage satis config file",
+}
+
+// configValidateCmd represents the config validate command
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [satis config file path]",
+	Short: "Validate satis config file",
+	Long: `Validate satis config file against the satis config schema and the Composer
+repository types. The path defaults to the one "serve" uses.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath := viper.GetString("config")
+		switch len(args) {
+		case 0:
+		case 1:
+			configPath = args[0]
+		default:
+			cmd.Usage()
+			os.Exit(2)
+		}
+
+		if err := validateConfigFile(configPath); err != nil {
+			os.Exit(1)
+		}
+		fmt.Printf("%s: valid\n", configPath)
+	},
+}
+
+// configHistoryCmd represents the config history command
+var configHistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List satis config revisions",
+	Run: func(cmd *cobra.Command, args []string) {
+		history := openConfigHistory()
+		revs, err := history.Revisions()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"revision", "time", "source", "jobs"})
+		for _, rev := range revs {
+			table.Append([]string{
+				strconv.Itoa(rev.Number),
+				rev.Time.Format(time.RFC3339),
+				rev.Source,
+				strings.Join(rev.JobIDs, ","),
+			})
+		}
+		table.Render()
+	},
+}
+
+// configDiffCmd represents the config diff command
+var configDiffCmd = &cobra.Command{
+	Use:   "diff <from revision> [to revision]",
+	Short: "Show changes between satis config revisions",
+	Long: `Show changes between satis config revisions in the unified format.
+The revision to compare with defaults to the latest one.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		numbers := revisionArgs(cmd, args, 1, 2)
+		if len(numbers) == 1 {
+			numbers = append(numbers, 0)
+		}
+
+		diff, err := openConfigHistory().Diff(numbers[0], numbers[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		fmt.Print(diff)
+	},
+}
+
+// configRollbackCmd represents the config rollback command
+var configRollbackCmd = &cobra.Command{
+	Use:   "rollback <revision>",
+	Short: "Restore a satis config revision and rebuild",
+	Long: `Restore a satis config revision and run a full rebuild with satis.
+While "serve" runs, use its API instead so that the builds do not overlap.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		number := revisionArgs(cmd, args, 1, 1)[0]
+
+		service := satis.NewService(satis.ServiceParam{
+			SatisPath:       viper.GetString("satis"),
+			ConfigPath:      viper.GetString("config"),
+			RepoPath:        viper.GetString("repo"),
+			Debug:           viper.GetBool("debug"),
+			Timeout:         time.Second * time.Duration(viper.GetInt("timeout")),
+			HistoryPath:     configHistoryPath(),
+			HistoryLimit:    viper.GetInt("config-history-limit"),
+			Generations:     viper.GetInt("generations"),
+			GenerationsPath: viper.GetString("generations-dir"),
+			Builder:         viper.GetString("builder"),
+			GitPath:         viper.GetString("git"),
+			MirrorPath:      viper.GetString("mirror-dir"),
+			// the rollback does not serve the repository
+			NoIndex: true,
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		stream := service.Run(ctx)
+		defer func() {
+			cancel()
+			for range stream {
+			}
+		}()
+
+		jobID, _, err := service.Rollback(number)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		for result := range stream {
+			if result.JobID != jobID {
+				continue
+			}
+			if result.Error != nil {
+				fmt.Fprintln(os.Stderr, result.Error.Error())
+				os.Exit(1)
+			}
+			fmt.Printf("restored revision %d\n", number)
+			return
+		}
+		// the service stopped before replying
+		fmt.Fprintf(os.Stderr, "rollback job %v finished without a result\n", jobID)
+		os.Exit(1)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configHistoryCmd)
+	configCmd.AddCommand(configDiffCmd)
+	configCmd.AddCommand(configRollbackCmd)
+}
+
+// openConfigHistory opens the config history "serve" keeps.
+func openConfigHistory() *satis.ConfigHistory {
+	history, err := satis.OpenConfigHistory(configHistoryPath(), viper.GetInt("config-history-limit"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	return history
+}
+
+// revisionArgs parses the revision numbers in args, between min and max of them.
+func revisionArgs(cmd *cobra.Command, args []string, min, max int) []int {
+	if len(args) < min || max < len(args) {
+		cmd.Usage()
+		os.Exit(2)
+	}
+	numbers := make([]int, len(args))
+	for i, arg := range args {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			fmt.Fprintf(os.Stderr, "invalid revision number %q\n", arg)
+			os.Exit(2)
+		}
+		numbers[i] = n
+	}
+	return numbers
+}
+
+// validateConfigFile validates the satis config file and prints the
+// problems found, one per line.
+func validateConfigFile(configPath string) error {
+	err := satis.ValidateConfigFile(configPath)
+	if verr, ok := err.(*satis.ValidationError); ok {
+		for _, e := range verr.Errors {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", configPath, e.Error())
+		}
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", configPath, err.Error())
+	}
+	return err
+}
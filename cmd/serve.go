@@ -26,9 +26,11 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/olekukonko/tablewriter"
+	"github.com/pkg/errors"
 	"github.com/reedom/satishub/api"
 	"github.com/reedom/satishub/pkg/satis"
 	"github.com/spf13/cobra"
@@ -50,6 +52,64 @@ var serveCmd = &cobra.Command{
 			Debug:       debug,
 			Timeout:     time.Second * time.Duration(viper.GetInt("timeout")),
 			SNSTopicARN: viper.GetString("sns-topic-arn"),
+			JobHistory:  viper.GetInt("job-history"),
+			JobMaxAge:   time.Second * time.Duration(viper.GetInt("job-max-age")),
+
+			Debounce:        time.Millisecond * time.Duration(viper.GetInt("debounce")),
+			BatchThreshold:  viper.GetInt("batch-threshold"),
+			Supersede:       viper.GetBool("supersede"),
+			Workers:         viper.GetInt("workers"),
+			QueueSize:       viper.GetInt("queue-size"),
+			QueuePath:       viper.GetString("queue-file"),
+			HistoryPath:     configHistoryPath(),
+			HistoryLimit:    viper.GetInt("config-history-limit"),
+			Generations:     viper.GetInt("generations"),
+			GenerationsPath: viper.GetString("generations-dir"),
+			Builder:         viper.GetString("builder"),
+			GitPath:         viper.GetString("git"),
+			MirrorPath:      viper.GetString("mirror-dir"),
+			NoIndex:         viper.GetBool("no-index"),
+			Retry: satis.RetryPolicy{
+				MaxAttempts:  viper.GetInt("retry"),
+				BackoffBase:  time.Second * time.Duration(viper.GetInt("retry-backoff")),
+				Jitter:       float64(viper.GetInt("retry-jitter")) / 100,
+				RetryTimeout: viper.GetBool("retry-timeout"),
+			},
+		}
+
+		gitlabPolicies, err := gitlabEventPolicies()
+		if err != nil {
+			log.Println(err.Error())
+			return
+		}
+		githubRepositoryDelete, err := removalPolicy("github-repository-delete")
+		if err != nil {
+			log.Println(err.Error())
+			return
+		}
+
+		// concurrent satis builds would overwrite packages.json and the
+		// include files of each other
+		if 1 < satisParam.Workers && satisParam.Builder != satis.BuilderNative {
+			log.Printf("--workers: %d partial builds at the same time need --builder %s", satisParam.Workers, satis.BuilderNative)
+			return
+		}
+
+		satisParam.Schedules, err = satis.ParseSchedules(viper.GetString("schedule"))
+		if err != nil {
+			log.Println(errors.Wrap(err, "--schedule").Error())
+			return
+		}
+
+		if err := validateConfigFile(satisParam.ConfigPath); err != nil {
+			log.Println("satis config file is invalid")
+			return
+		}
+
+		bitbucketClone := viper.GetString("bitbucket-clone")
+		if bitbucketClone != "ssh" && bitbucketClone != "https" {
+			log.Printf("--bitbucket-clone: unknown protocol %q (ssh or https)", bitbucketClone)
+			return
 		}
 
 		useHTTP := !viper.GetBool("no-http")
@@ -86,10 +146,26 @@ var serveCmd = &cobra.Command{
 
 		service := satis.NewService(satisParam)
 
-		server := api.NewServer(service, logger, satisParam.Debug)
+		server := api.NewServer(api.ServerParam{
+			Service:       service,
+			Log:           logger,
+			Debug:         satisParam.Debug,
+			GitlabSecrets: secretList(viper.GetString("gitlab-secret")),
+			GithubSecrets: secretList(viper.GetString("github-secret")),
+
+			GitlabPolicies:         gitlabPolicies,
+			GithubRepositoryDelete: githubRepositoryDelete,
+
+			BitbucketSecrets: secretList(viper.GetString("bitbucket-secret")),
+			BitbucketClone:   bitbucketClone,
+
+			GiteaSecrets: secretList(viper.GetString("gitea-secret")),
+
+			APITokens: secretList(viper.GetString("api-token")),
+		})
 
 		go func() {
-			interrupt := make(chan os.Signal)
+			interrupt := make(chan os.Signal, 1)
 			signal.Notify(interrupt, os.Interrupt)
 			select {
 			case <-interrupt:
@@ -125,7 +201,7 @@ var serveCmd = &cobra.Command{
 					break loop
 				}
 				if result.Error != nil {
-					logger.Printf("ERROR: %v", result.Error.Error())
+					logger.Printf("ERROR: job %v: %v", result.JobID, result.Error.Error())
 					continue
 				}
 				if debug {
@@ -172,6 +248,39 @@ func init() {
 		{"tlscert", "SATIS_TLS_CERT_PATH", "satis.crt", "TLS certificate file path"},
 		{"tlskey", "SATIS_TLS_SECRET_KEY_PATH", "satis.key", "TLS secret key file path"},
 		{"sns-topic-arn", "SATIS_SNS_TOPIC_ARN", "", "AWS Simple Notification Service ARN"},
+		{"job-history", "SATIS_JOB_HISTORY", int(100), "number of finished jobs and their logs to be kept(0 for no limit)"},
+		{"job-max-age", "SATIS_JOB_MAX_AGE", int(60 * 60 * 24 * 7), "seconds to keep finished jobs and their logs(0 for no limit)"},
+		{"debounce", "SATIS_DEBOUNCE", int(0), "milliseconds to wait for more package updates to be built together(0 to disable)"},
+		{"batch-threshold", "SATIS_BATCH_THRESHOLD", int(10), "number of packages built together beyond which a full rebuild runs(0 for no limit)"},
+		{"supersede", "SATIS_SUPERSEDE", false, "let a full rebuild request cancel the running build"},
+		{"workers", "SATIS_WORKERS", int(1), "number of partial builds of different packages which run at the same time with the native builder"},
+		{"retry", "SATIS_RETRY", int(1), "max satis build attempts per job(1 to disable retrying)"},
+		{"retry-backoff", "SATIS_RETRY_BACKOFF", int(30), "seconds to wait before the first retry, doubled on each retry"},
+		{"retry-jitter", "SATIS_RETRY_JITTER", int(20), "percentage to randomize the retry backoff by"},
+		{"retry-timeout", "SATIS_RETRY_TIMEOUT", false, "retry satis builds killed by the timeout as well"},
+		{"schedule", "SATIS_SCHEDULE", "", "periodic builds; cron expressions optionally followed by package names, separated by ';'"},
+		{"queue-size", "SATIS_QUEUE_SIZE", int(16), "number of requests which can wait for execution"},
+		{"queue-file", "SATIS_QUEUE_FILE", "", "path to the journal file to keep queued requests across restarts"},
+		{"config-history", "SATIS_CONFIG_HISTORY", "", "directory to keep satis config revisions(default: <config>.history)"},
+		{"config-history-limit", "SATIS_CONFIG_HISTORY_LIMIT", int(100), "number of satis config revisions to be kept(0 for no limit)"},
+		{"generations", "SATIS_GENERATIONS", int(0), "number of output directories of full rebuilds to be kept for switching back(0 to build in place)"},
+		{"generations-dir", "SATIS_GENERATIONS_DIR", "", "directory to keep output generations(default: <repo>.generations)"},
+		{"builder", "SATIS_BUILDER", "satis", "metadata builder, satis or native(Composer 2 metadata of vcs, git and package repositories without satis)"},
+		{"git", "SATIS_GIT_PATH", "git", "git command path for the native builder"},
+		{"mirror-dir", "SATIS_MIRROR_DIR", "", "directory to keep git mirrors for the native builder(default: <repo>.mirrors)"},
+		{"no-index", "SATIS_NO_INDEX", false, "serve packages.json and p2 metadata as files instead of from the in-memory index with ETag"},
+		{"gitlab-secret", "SATIS_GITLAB_SECRET", "", "GitLab WebHook secret token(comma separated to accept several)"},
+		{"github-secret", "SATIS_GITHUB_SECRET", "", "GitHub WebHook secret(comma separated to accept several)"},
+		{"gitlab-push", "SATIS_GITLAB_PUSH", "build", "policy on GitLab push event(build, ignore or rebuild)"},
+		{"gitlab-tag-push", "SATIS_GITLAB_TAG_PUSH", "build", "policy on GitLab tag push event(build, ignore or rebuild)"},
+		{"gitlab-repository-update", "SATIS_GITLAB_REPOSITORY_UPDATE", "build", "policy on GitLab repository update system event(build, ignore or rebuild)"},
+		{"gitlab-merge", "SATIS_GITLAB_MERGE", "ignore", "policy on GitLab merge request merged event(build, ignore or rebuild)"},
+		{"gitlab-project-destroy", "SATIS_GITLAB_PROJECT_DESTROY", "ignore", "policy on GitLab project destroy system event(remove or ignore)"},
+		{"github-repository-delete", "SATIS_GITHUB_REPOSITORY_DELETE", "ignore", "policy on GitHub repository deleted event(remove or ignore)"},
+		{"bitbucket-secret", "SATIS_BITBUCKET_SECRET", "", "Bitbucket WebHook secret(comma separated to accept several)"},
+		{"bitbucket-clone", "SATIS_BITBUCKET_CLONE", "ssh", "repository URL protocol for Bitbucket repositories(ssh or https)"},
+		{"gitea-secret", "SATIS_GITEA_SECRET", "", "Gitea/Forgejo/Gogs WebHook secret(comma separated to accept several)"},
+		{"api-token", "SATIS_API_TOKEN", "", "bearer token for /api/v1 endpoints(comma separated to accept several)"},
 	}
 	for _, f := range appFlags {
 		switch f.defVal.(type) {
@@ -190,3 +299,60 @@ func init() {
 		}
 	}
 }
+
+// configHistoryPath returns the directory of the satis config revisions.
+func configHistoryPath() string {
+	if path := viper.GetString("config-history"); path != "" {
+		return path
+	}
+	return viper.GetString("config") + ".history"
+}
+
+// secretList splits a comma separated secret list.
+func secretList(value string) []string {
+	var secrets []string
+	for _, secret := range strings.Split(value, ",") {
+		secret = strings.TrimSpace(secret)
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
+// gitlabEventPolicies reads GitLab event policies from the settings.
+func gitlabEventPolicies() (map[string]api.EventPolicy, error) {
+	flags := map[string]string{
+		api.GitlabPush:             "gitlab-push",
+		api.GitlabTagPush:          "gitlab-tag-push",
+		api.GitlabRepositoryUpdate: "gitlab-repository-update",
+		api.GitlabMergeRequest:     "gitlab-merge",
+		api.GitlabProjectDestroy:   "gitlab-project-destroy",
+	}
+
+	policies := make(map[string]api.EventPolicy)
+	for kind, flag := range flags {
+		policy, err := api.ParseEventPolicy(viper.GetString(flag))
+		if err != nil {
+			return nil, errors.Wrapf(err, "--%s", flag)
+		}
+		// only a deletion event can remove, and it can do nothing else
+		if (kind == api.GitlabProjectDestroy) != (policy == api.PolicyRemove) && policy != api.PolicyIgnore {
+			return nil, errors.Errorf("--%s: event policy %q is not allowed", flag, policy)
+		}
+		policies[kind] = policy
+	}
+	return policies, nil
+}
+
+// removalPolicy reads the policy of a deletion event from the settings.
+func removalPolicy(flag string) (api.EventPolicy, error) {
+	policy, err := api.ParseEventPolicy(viper.GetString(flag))
+	if err != nil {
+		return "", errors.Wrapf(err, "--%s", flag)
+	}
+	if policy != api.PolicyRemove && policy != api.PolicyIgnore {
+		return "", errors.Errorf("--%s: event policy %q is not allowed", flag, policy)
+	}
+	return policy, nil
+}
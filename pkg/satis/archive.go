@@ -0,0 +1,176 @@
+package satis
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// unsafeFileChars are the characters replaced in the archive file names.
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// archiver makes the dist archives of the versions the native builder reads
+// from git, following the "archive" section of the satis config as satis
+// does. An archive is named after its commit, so that it is made once and
+// reused by the later builds.
+type archiver struct {
+	// dir is the absolute path of the archive directory.
+	dir string
+	// urlPrefix is the URL of the archive directory.
+	urlPrefix string
+	format    string
+	skipDev   bool
+	checksum  bool
+	rearchive bool
+	whitelist []string
+	blacklist []string
+	// written are the archives the build has made or reused.
+	written map[string]bool
+}
+
+// newArchiver returns the archiver of the satis config, or nil when it has
+// no "archive" section.
+func newArchiver(config map[string]interface{}, outputDir string) (*archiver, error) {
+	archive, ok := config["archive"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	directory, _ := archive["directory"].(string)
+	if directory == "" {
+		return nil, errors.Errorf(`archive: "directory" is required`)
+	}
+
+	a := &archiver{format: "zip", checksum: true, written: make(map[string]bool)}
+	dir := filepath.Join(outputDir, filepath.FromSlash(directory))
+	if absolute, _ := archive["absolute-directory"].(string); absolute != "" {
+		dir = absolute
+	}
+	var err error
+	if a.dir, err = filepath.Abs(dir); err != nil {
+		return nil, errors.Errorf("archive: %s", err)
+	}
+
+	prefix, _ := archive["prefix-url"].(string)
+	if prefix == "" {
+		prefix, _ = config["homepage"].(string)
+	}
+	a.urlPrefix = strings.TrimSuffix(prefix, "/") + "/" + strings.Trim(directory, "/")
+	if format, _ := archive["format"].(string); format != "" {
+		a.format = format
+	}
+	if checksum, ok := archive["checksum"].(bool); ok {
+		a.checksum = checksum
+	}
+	a.skipDev, _ = archive["skip-dev"].(bool)
+	a.rearchive, _ = archive["rearchive"].(bool)
+	a.whitelist = stringList(archive["whitelist"])
+	a.blacklist = stringList(archive["blacklist"])
+	return a, nil
+}
+
+// wanted determines whether the version of the package is archived.
+func (a *archiver) wanted(name, normalized string) bool {
+	if a.skipDev && isDevVersion(normalized) {
+		return false
+	}
+	if 0 < len(a.whitelist) && !contains(a.whitelist, name) {
+		return false
+	}
+	return !contains(a.blacklist, name)
+}
+
+// archive makes the archive of the commit unless it exists, and returns
+// the "dist" of the version.
+func (a *archiver) archive(ctx context.Context, m *gitMirror, name, version, commit string, out io.Writer) (map[string]string, error) {
+	file := unsafeFileChars.ReplaceAllString(strings.Replace(name, "/", "-", -1)+"-"+version, "-") + "-" + commit + "." + a.format
+	rel := path.Join(name, file)
+	target := filepath.Join(a.dir, filepath.FromSlash(rel))
+	a.written[target] = true
+
+	if _, err := os.Stat(target); err != nil || a.rearchive {
+		fmt.Fprintf(out, "Dumping %s %s\n", name, version)
+		if err = m.archive(ctx, commit, a.format, target); err != nil {
+			return nil, err
+		}
+	}
+
+	dist := map[string]string{"type": a.format, "url": a.urlPrefix + "/" + rel, "reference": commit, "shasum": ""}
+	if a.checksum {
+		sum, err := fileSHA1(target)
+		if err != nil {
+			return nil, errors.Errorf("failed to read archive of %s %s: %s", name, version, err)
+		}
+		dist["shasum"] = sum
+	}
+	return dist, nil
+}
+
+// prune removes the archives which the build has not written, of the
+// packages of names, or of all packages when names is empty.
+func (a *archiver) prune(names []string) error {
+	roots := []string{a.dir}
+	if 0 < len(names) {
+		roots = roots[:0]
+		for _, name := range names {
+			roots = append(roots, filepath.Join(a.dir, filepath.FromSlash(name)))
+		}
+	}
+
+	for _, root := range roots {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || a.written[path] {
+				return err
+			}
+			if ext := filepath.Ext(path); ext != ".zip" && ext != ".tar" {
+				return nil
+			}
+			return os.Remove(path)
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Errorf("failed to remove old archives: %s", err)
+		}
+	}
+	return nil
+}
+
+// fileSHA1 returns the SHA-1 hex digest of the file, which Composer checks
+// the downloads with.
+func fileSHA1(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha1.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func stringList(value interface{}) []string {
+	list, _ := value.([]interface{})
+	values := make([]string, 0, len(list))
+	for _, v := range list {
+		if s, ok := v.(string); ok {
+			values = append(values, strings.ToLower(s))
+		}
+	}
+	return values
+}
+
+// hasArchive determines whether the satis config makes dist archives.
+func hasArchive(config map[string]interface{}) bool {
+	_, ok := config["archive"].(map[string]interface{})
+	return ok
+}
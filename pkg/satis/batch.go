@@ -0,0 +1,245 @@
+package satis
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// maxDebounceFactor limits how long a burst of requests can postpone the build,
+// in multiples of the debounce window.
+const maxDebounceFactor = 10
+
+// partialCollector gathers the partial build requests arriving within the
+// debounce window into a batch. The service loop waits for the end of the
+// window in its select, so the other requests are not held back meanwhile.
+type partialCollector struct {
+	window   time.Duration
+	batch    []requestPartial
+	timer    *time.Timer
+	deadline time.Time
+}
+
+// add adds the request to the batch and restarts the window, but not beyond
+// the deadline of the batch.
+func (c *partialCollector) add(req requestPartial) {
+	if len(c.batch) == 0 {
+		c.timer = time.NewTimer(c.window)
+		c.deadline = time.Now().Add(c.window * maxDebounceFactor)
+	} else {
+		wait := c.window
+		if rest := time.Until(c.deadline); rest < wait {
+			wait = rest
+		}
+		if !c.timer.Stop() {
+			<-c.timer.C
+		}
+		c.timer.Reset(wait)
+	}
+	c.batch = append(c.batch, req)
+}
+
+// collecting determines whether a batch is being collected.
+func (c *partialCollector) collecting() bool {
+	return 0 < len(c.batch)
+}
+
+// done returns the channel which fires at the end of the window, or nil
+// while no batch is collected.
+func (c *partialCollector) done() <-chan time.Time {
+	if c.timer == nil {
+		return nil
+	}
+	return c.timer.C
+}
+
+// flush appends the collected batch to waiting and starts a new one.
+func (c *partialCollector) flush(waiting [][]requestPartial) [][]requestPartial {
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	if 0 < len(c.batch) {
+		waiting = append(waiting, c.batch)
+	}
+	c.batch, c.timer = nil, nil
+	return waiting
+}
+
+// mergePackages removes duplicate packages. A later request overrides the
+// former one of the same package.
+func mergePackages(batch []requestPartial) []PackageInfo {
+	var pkgs []PackageInfo
+	index := make(map[string]int)
+	for _, req := range batch {
+		key := packageKey(req.PackageInfo)
+		if i, ok := index[key]; ok {
+			// a scheduled request without URL does not override
+			if req.URL != "" {
+				pkgs[i] = req.PackageInfo
+			}
+			continue
+		}
+		index[key] = len(pkgs)
+		pkgs = append(pkgs, req.PackageInfo)
+	}
+	return pkgs
+}
+
+// packageKey identifies the package of a request by its name, or by its URL
+// when the name is unknown.
+func packageKey(pkg PackageInfo) string {
+	if pkg.Name != "" {
+		return pkg.Name
+	}
+	return pkg.URL
+}
+
+// configUpdates returns the packages to be updated in the satis config.
+// The packages without URL, requested by schedules, are in the config already.
+func configUpdates(pkgs []PackageInfo) []PackageInfo {
+	var updates []PackageInfo
+	for _, pkg := range pkgs {
+		if pkg.URL != "" {
+			updates = append(updates, pkg)
+		}
+	}
+	return updates
+}
+
+// runPartials updates the satis config with the whole batch at once and runs
+// a single satis build for all of the packages. The build turns into a full
+// rebuild when a package lacks its name or the batch exceeds batchThreshold.
+func (s *service) runPartials(ctx context.Context, b *build, batch []requestPartial, result chan<- ServiceResult) {
+	// skip the cancelled requests
+	started := batch[:0]
+	for _, req := range batch {
+		if s.jobs.start(req.JobID) {
+			started = append(started, req)
+		} else {
+			s.reply(result, req.JobID, req.Result, ErrJobCancelled)
+		}
+	}
+	batch = started
+	if len(batch) == 0 {
+		return
+	}
+
+	pkgs := mergePackages(batch)
+
+	for _, pkg := range pkgs {
+		if err := s.notifyPartialBuild(pkg, "start", nil); err != nil {
+			s.errLog.Println("notify error", err.Error())
+		}
+	}
+
+	jobIDs, out := s.batchOutput(batch)
+	updates := configUpdates(pkgs)
+	var err error
+	if 0 < len(updates) {
+		err = s.changeConfig(jobIDs, packageSources(updates, "update"), func() error {
+			return UpdateConfig(s.configPath, updates)
+		})
+	}
+	for err == nil {
+		// the jobs cancelled out of the batch leave it, and the rest is
+		// built again without them
+		if batch, pkgs = s.dropCancelled(b, batch, pkgs, result); len(batch) == 0 {
+			return
+		}
+		jobIDs, out = s.batchOutput(batch)
+		names, full := s.buildTargets(pkgs)
+		if s.debug {
+			s.stdLog.Printf("batch of %d requests: packages %v, full rebuild %v", len(batch), names, full)
+		}
+		try, cancel := b.try(ctx)
+		err = s.withRetry(try, jobIDs, out, func(ctx context.Context) error {
+			if full {
+				return s.rebuild(ctx, out)
+			}
+			return s.partialBuild(ctx, names, out)
+		})
+		cancel()
+		if err == nil || ctx.Err() != nil || !b.dropping() {
+			break
+		}
+		err = nil
+	}
+	err = b.result(err)
+	if err == nil {
+		s.reloadIndex()
+	}
+
+	for _, pkg := range pkgs {
+		if err != nil {
+			s.notifyPartialBuild(pkg, "error", err)
+		} else if nerr := s.notifyPartialBuild(pkg, "completed", nil); nerr != nil {
+			s.errLog.Println("notify error", nerr.Error())
+		}
+	}
+
+	for _, req := range batch {
+		s.jobs.finish(req.JobID, err)
+		s.reply(result, req.JobID, req.Result, err)
+	}
+}
+
+// batchOutput returns the job IDs of the batch and the writer to their outputs.
+func (s *service) batchOutput(batch []requestPartial) ([]string, io.Writer) {
+	jobIDs := make([]string, len(batch))
+	outs := make([]io.Writer, len(batch))
+	for i, req := range batch {
+		jobIDs[i] = req.JobID
+		outs[i] = s.jobs.output(req.JobID)
+	}
+	return jobIDs, io.MultiWriter(outs...)
+}
+
+// dropCancelled finishes the jobs cancelled out of the running batch, and
+// returns the rest of the batch with its packages.
+func (s *service) dropCancelled(b *build, batch []requestPartial, pkgs []PackageInfo, result chan<- ServiceResult) ([]requestPartial, []PackageInfo) {
+	dropped := b.takeDropped()
+	if len(dropped) == 0 {
+		return batch, pkgs
+	}
+
+	var rest []requestPartial
+	for _, req := range batch {
+		if dropped[req.JobID] {
+			s.jobs.finish(req.JobID, ErrJobCancelled)
+			s.reply(result, req.JobID, req.Result, ErrJobCancelled)
+		} else {
+			rest = append(rest, req)
+		}
+	}
+
+	left := mergePackages(rest)
+	keys := make(map[string]bool)
+	for _, pkg := range left {
+		keys[packageKey(pkg)] = true
+	}
+	for _, pkg := range pkgs {
+		if !keys[packageKey(pkg)] {
+			s.notifyPartialBuild(pkg, "error", ErrJobCancelled)
+		}
+	}
+	return rest, left
+}
+
+// buildTargets returns the package names to build, or full=true when
+// the packages need a full rebuild.
+func (s *service) buildTargets(pkgs []PackageInfo) (names []string, full bool) {
+	seen := make(map[string]bool)
+	for _, pkg := range pkgs {
+		if pkg.Name == "" {
+			return nil, true
+		}
+		if !seen[pkg.Name] {
+			seen[pkg.Name] = true
+			names = append(names, pkg.Name)
+		}
+	}
+	if 0 < s.batchThreshold && s.batchThreshold < len(names) {
+		return nil, true
+	}
+	return names, false
+}
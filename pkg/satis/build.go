@@ -0,0 +1,189 @@
+package satis
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrJobCancelled is the cause of errors of cancelled jobs.
+var ErrJobCancelled = errors.New("job cancelled")
+
+// ErrJobNotFound is returned by Cancel for an unknown job.
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrJobFinished is returned by Cancel for a job already finished.
+var ErrJobFinished = errors.New("job already finished")
+
+// build represents a satis build running for one or more jobs.
+type build struct {
+	jobIDs []string
+	// packages are the names of the packages being built.
+	packages []string
+	// exclusive is set for a full rebuild, which runs alone.
+	exclusive bool
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	mu     sync.Mutex
+	reason error
+	// dropped are the jobs cancelled out of the build, which goes on without
+	// them, and attempt cancels the current try of the build.
+	dropped map[string]bool
+	attempt context.CancelFunc
+}
+
+// stop cancels the build. reason becomes the error of the build jobs.
+func (b *build) stop(reason error) {
+	b.mu.Lock()
+	if b.reason == nil {
+		b.reason = reason
+	}
+	b.mu.Unlock()
+	b.cancel()
+}
+
+// result replaces the build error with the reason of stop(), if any.
+func (b *build) result(err error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil && b.reason != nil {
+		return b.reason
+	}
+	return err
+}
+
+// try returns the context of a try of the build, which drop cancels.
+func (b *build) try(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	b.mu.Lock()
+	b.attempt = cancel
+	b.mu.Unlock()
+	return ctx, cancel
+}
+
+// drop cancels the job out of the build, interrupting the current try so
+// that the build starts over without the job.
+func (b *build) drop(jobID string) {
+	b.mu.Lock()
+	if b.dropped == nil {
+		b.dropped = make(map[string]bool)
+	}
+	b.dropped[jobID] = true
+	attempt := b.attempt
+	b.mu.Unlock()
+	if attempt != nil {
+		attempt()
+	}
+}
+
+// dropping determines whether some jobs are waiting to be dropped.
+func (b *build) dropping() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return 0 < len(b.dropped)
+}
+
+// takeDropped returns the jobs dropped since the last call.
+func (b *build) takeDropped() map[string]bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	dropped := b.dropped
+	b.dropped = nil
+	return dropped
+}
+
+func (b *build) has(jobID string) bool {
+	for _, id := range b.jobIDs {
+		if id == jobID {
+			return true
+		}
+	}
+	return false
+}
+
+// startBuild runs fn in a new goroutine as one of the running builds.
+// The build is sent to s.finished when fn returns.
+func (s *service) startBuild(ctx context.Context, b *build, fn func(ctx context.Context, b *build)) *build {
+	ctxBuild, cancel := context.WithCancel(ctx)
+	b.cancel = cancel
+	b.done = make(chan struct{})
+
+	s.mu.Lock()
+	s.running[b] = true
+	s.mu.Unlock()
+
+	go func() {
+		defer func() { s.finished <- b }()
+		defer close(b.done)
+		defer cancel()
+		fn(ctxBuild, b)
+	}()
+	return b
+}
+
+// endBuild removes the build from the running builds.
+func (s *service) endBuild(b *build) {
+	s.mu.Lock()
+	delete(s.running, b)
+	s.mu.Unlock()
+}
+
+// runningBuilds returns the running builds.
+func (s *service) runningBuilds() []*build {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	builds := make([]*build, 0, len(s.running))
+	for b := range s.running {
+		builds = append(builds, b)
+	}
+	return builds
+}
+
+// stopBuilds stops all of the running builds and waits for them.
+func (s *service) stopBuilds(reason error) {
+	for _, b := range s.runningBuilds() {
+		b.stop(reason)
+		<-b.done
+		s.endBuild(b)
+	}
+}
+
+// Cancel cancels the job. A queued job will be skipped, and a running job
+// gets its satis process killed. When the job is merged into a single build
+// with others, the build starts over without it.
+func (s *service) Cancel(jobID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for b := range s.running {
+		if !b.has(jobID) {
+			continue
+		}
+		if job, ok := s.jobs.get(jobID); ok && !job.State.Finished() {
+			if 1 < s.unfinished(b.jobIDs) {
+				b.drop(jobID)
+			} else {
+				b.stop(ErrJobCancelled)
+			}
+			return nil
+		}
+	}
+	if err := s.jobs.cancelQueued(jobID); err != nil {
+		return err
+	}
+	s.journalDone(jobID)
+	return nil
+}
+
+// unfinished returns the number of the jobs not finished yet.
+func (s *service) unfinished(jobIDs []string) int {
+	n := 0
+	for _, id := range jobIDs {
+		if job, ok := s.jobs.get(id); ok && !job.State.Finished() {
+			n++
+		}
+	}
+	return n
+}
@@ -1,17 +1,122 @@
 package satis
 
 import (
-	"encoding/json"
+	"bytes"
 	"io/ioutil"
+	"strings"
 
 	"github.com/json-iterator/go"
 	"github.com/pkg/errors"
 )
 
 // UpdateConfig updates the satis configuration entries.
+// The file is locked during the update and replaced atomically.
 func UpdateConfig(configPath string, updates []PackageInfo) error {
+	return editConfig(configPath, func(c *configEdit) error {
+		for _, u := range updates {
+			if err := c.updateRepository(u); err != nil {
+				return err
+			}
+			if u.Version != "" && c.requires[u.Name] != u.Version {
+				if err := c.setRequire(u.Name, u.Version); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// RemoveConfig drops the "repositories" entries and the "require" keys of
+// the packages. A repository matches the package URL, or the package name
+// when the URL is empty.
+func RemoveConfig(configPath string, removes []PackageInfo) error {
+	return editConfig(configPath, func(c *configEdit) error {
+		for _, u := range removes {
+			for i := len(c.repos) - 1; 0 <= i; i-- {
+				url, _ := c.repos[i]["url"].(string)
+				if !repositoryMatches(url, u) {
+					continue
+				}
+				if err := c.editor.remove([]interface{}{"repositories"}, i); err != nil {
+					return err
+				}
+				c.repos = append(c.repos[:i], c.repos[i+1:]...)
+			}
+			if _, ok := c.requires[u.Name]; ok && u.Name != "" {
+				if err := c.editor.remove([]interface{}{"require"}, u.Name); err != nil {
+					return err
+				}
+				delete(c.requires, u.Name)
+			}
+		}
+		return nil
+	})
+}
+
+// restoreConfig replaces the satis config with data, such as a revision
+// from the config history.
+func restoreConfig(configPath string, data []byte) error {
+	return editConfig(configPath, func(c *configEdit) error {
+		editor, err := newJSONEditor(data)
+		if err != nil {
+			return errors.Errorf("invalid JSON content: %s", err)
+		}
+		c.editor = editor
+		return nil
+	})
+}
+
+// repositoryMatches determines whether the repository URL is of the package.
+func repositoryMatches(url string, pkg PackageInfo) bool {
+	if pkg.URL != "" {
+		return url == pkg.URL
+	}
+	if pkg.Name == "" {
+		return false
+	}
+	return repositoryPath(url) == strings.ToLower(pkg.Name)
+}
+
+// repositoryPath returns the whole path of the repository URL after the host,
+// or after ":" of an scp-like URL, lowercased and without ".git".
+func repositoryPath(url string) string {
+	url = strings.ToLower(url)
+	if i := strings.Index(url, "://"); 0 <= i {
+		url = url[i+3:]
+		if j := strings.IndexByte(url, '/'); 0 <= j {
+			url = url[j+1:]
+		} else {
+			url = ""
+		}
+	} else if i := strings.IndexByte(url, ':'); 0 <= i {
+		url = url[i+1:]
+	}
+	return strings.TrimSuffix(strings.Trim(url, "/"), ".git")
+}
+
+// configEdit holds the satis config being edited.
+type configEdit struct {
+	editor *jsonEditor
+	config map[string]interface{}
+	// repos and requires are the decoded "repositories" and "require" kept
+	// in sync with the editor.
+	repos    []map[string]interface{}
+	requires map[string]interface{}
+}
+
+// editConfig reads the satis config, lets fn edit it and writes it back.
+// The file is locked during the edit and replaced atomically. The edit is
+// rejected with *ValidationError when it brings new problems to the config.
+func editConfig(configPath string, fn func(c *configEdit) error) error {
 	var config map[string]interface{}
 
+	unlock, err := lockPath(configPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	data, err := ioutil.ReadFile(configPath)
 	if err != nil {
 		return errors.Errorf("failed to open satis config file: %s", err.Error())
@@ -32,40 +137,29 @@ func UpdateConfig(configPath string, updates []PackageInfo) error {
 		return err
 	}
 
-	for _, u := range updates {
-		found := false
-		for _, repo := range repos {
-			if repo["url"] == u.URL {
-				repo["type"] = u.Type
-				found = true
-				break
-			}
-		}
-
-		if !found {
-			repos = append(repos, map[string]interface{}{
-				"url":  u.URL,
-				"type": u.Type,
-			})
-		}
-
-		if u.Version != "" {
-			requires[u.Name] = u.Version
-		}
+	editor, err := newJSONEditor(data)
+	if err != nil {
+		return errors.Errorf("satis config file contains invalid JSON content: %s", err.Error())
 	}
 
-	config["repositories"] = repos
-	if 0 < len(requires) {
-		config["require"] = requires
+	problems := validateConfig(config)
+	c := &configEdit{editor: editor, config: config, repos: repos, requires: requires}
+	if err = fn(c); err != nil {
+		return errors.Wrap(err, "failed to update satis config")
+	}
+	if bytes.Equal(data, c.editor.Bytes()) {
+		return nil
 	}
 
-	data, err = json.MarshalIndent(config, "", "  ")
-	if err != nil {
-		return errors.Errorf("failed to encode satis config file: %s", err)
+	var edited map[string]interface{}
+	if err = jsoniter.Unmarshal(c.editor.Bytes(), &edited); err != nil {
+		return errors.Errorf("failed to update satis config: %s", err)
+	}
+	if errs := introducedErrors(problems, validateConfig(edited)); 0 < len(errs) {
+		return &ValidationError{Errors: errs}
 	}
 
-	// FIXME use temporary file to prevent possibly making a broken file when disk got full
-	err = ioutil.WriteFile(configPath, data, 0644)
+	err = writeFileAtomic(configPath, c.editor.Bytes(), 0644)
 	if err != nil {
 		return errors.Errorf("failed to write satis config file: %s", err)
 	}
@@ -73,6 +167,48 @@ func UpdateConfig(configPath string, updates []PackageInfo) error {
 	return nil
 }
 
+// configRepository is a "repositories" entry added to the config.
+type configRepository struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// updateRepository sets the type of the repository of the package, or adds
+// the repository if there is none.
+func (c *configEdit) updateRepository(u PackageInfo) error {
+	for i, repo := range c.repos {
+		if repo["url"] != u.URL {
+			continue
+		}
+		if repo["type"] == u.Type {
+			return nil
+		}
+		repo["type"] = u.Type
+		return c.editor.set([]interface{}{"repositories", i}, "type", u.Type)
+	}
+
+	c.repos = append(c.repos, map[string]interface{}{"url": u.URL, "type": u.Type})
+	entry := configRepository{Type: u.Type, URL: u.URL}
+	if _, ok := c.config["repositories"]; ok {
+		return c.editor.append([]interface{}{"repositories"}, entry)
+	}
+	c.config["repositories"] = c.repos
+	return c.editor.set(nil, "repositories", []configRepository{entry})
+}
+
+// setRequire sets the version constraint of the package.
+func (c *configEdit) setRequire(name, version string) error {
+	var err error
+	if _, ok := c.config["require"]; ok {
+		err = c.editor.set([]interface{}{"require"}, name, version)
+	} else {
+		err = c.editor.set(nil, "require", map[string]string{name: version})
+		c.config["require"] = c.requires
+	}
+	c.requires[name] = version
+	return err
+}
+
 func configReadRepos(config map[string]interface{}) ([]map[string]interface{}, error) {
 	tmp, ok := config["repositories"]
 	if !ok {
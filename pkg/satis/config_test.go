@@ -1,10 +1,14 @@
 package satis_test
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/pkg/errors"
 	"github.com/reedom/satishub/pkg/satis"
 	"github.com/stretchr/testify/assert"
 )
@@ -56,7 +60,7 @@ func TestUpdateConfig(t *testing.T) {
 	updates := []satis.PackageInfo{satis.PackageInfo{
 		Name:    "test/another-pkg",
 		URL:     "http://example.com/another-pkg",
-		Type:    "something",
+		Type:    "git",
 		Version: "1.0.2",
 	}}
 	assert.NoError(t, satis.UpdateConfig(tmp.Name(), updates))
@@ -68,7 +72,7 @@ func TestUpdateConfig(t *testing.T) {
       "url": "http://example.com/pkg"
     },
     {
-      "type": "something",
+      "type": "git",
       "url": "http://example.com/another-pkg"
     }
   ],
@@ -134,3 +138,401 @@ func TestInvalidConfigJSON4(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), `config entry "require" is not a hash`)
 }
+
+func TestUpdateConfigKeepsMode(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "satis-test")
+	assert.NoError(t, err)
+	tmp.WriteString(`{}`)
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	defer os.Remove(tmp.Name() + ".lock")
+	assert.NoError(t, os.Chmod(tmp.Name(), 0640))
+
+	updates := []satis.PackageInfo{{Name: "test/pkg", URL: "http://example.com/pkg", Type: "vcs"}}
+	assert.NoError(t, satis.UpdateConfig(tmp.Name(), updates))
+
+	fi, err := os.Stat(tmp.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+}
+
+func TestUpdateConfigConcurrently(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "satis-test")
+	assert.NoError(t, err)
+	tmp.WriteString(`{}`)
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	defer os.Remove(tmp.Name() + ".lock")
+
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			url := fmt.Sprintf("http://example.com/pkg%d", i)
+			assert.NoError(t, satis.UpdateConfig(tmp.Name(), []satis.PackageInfo{{URL: url, Type: "vcs"}}))
+		}(i)
+	}
+	wg.Wait()
+
+	config, err := ioutil.ReadFile(tmp.Name())
+	assert.NoError(t, err)
+	for i := 0; i < n; i++ {
+		assert.Contains(t, string(config), fmt.Sprintf("http://example.com/pkg%d", i))
+	}
+}
+
+func TestUpdateConfigKeepsLayout(t *testing.T) {
+	content := `{
+    "name": "My Satis Repository",
+    "homepage": "http://satis.example.com",
+    "repositories": [
+        { "type": "vcs", "url": "http://example.com/pkg" },
+        {
+            "url": "http://example.com/old-pkg",
+            "type": "git"
+        }
+    ],
+    "require-all": false,
+    "require": {
+        "test/pkg": "*"
+    },
+    "archive": {"directory": "dist"}
+}
+`
+
+	tmp, err := ioutil.TempFile("", "satis-test")
+	assert.NoError(t, err)
+	tmp.WriteString(content)
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	defer os.Remove(tmp.Name() + ".lock")
+
+	updates := []satis.PackageInfo{
+		{Name: "test/old-pkg", URL: "http://example.com/old-pkg", Type: "vcs", Version: "^2.0"},
+		{Name: "test/new-pkg", URL: "http://example.com/new-pkg", Type: "vcs", Version: "1.0.2"},
+		{Name: "test/pkg", URL: "http://example.com/pkg", Type: "vcs"},
+	}
+	assert.NoError(t, satis.UpdateConfig(tmp.Name(), updates))
+
+	expected := `{
+    "name": "My Satis Repository",
+    "homepage": "http://satis.example.com",
+    "repositories": [
+        { "type": "vcs", "url": "http://example.com/pkg" },
+        {
+            "url": "http://example.com/old-pkg",
+            "type": "vcs"
+        },
+        {
+            "type": "vcs",
+            "url": "http://example.com/new-pkg"
+        }
+    ],
+    "require-all": false,
+    "require": {
+        "test/pkg": "*",
+        "test/old-pkg": "^2.0",
+        "test/new-pkg": "1.0.2"
+    },
+    "archive": {"directory": "dist"}
+}
+`
+	config, err := ioutil.ReadFile(tmp.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(config))
+}
+
+func TestUpdateCompactConfig(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "satis-test")
+	assert.NoError(t, err)
+	tmp.WriteString(`{"name":"repo","repositories":[]}`)
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	defer os.Remove(tmp.Name() + ".lock")
+
+	updates := []satis.PackageInfo{
+		{Name: "test/a", URL: "http://example.com/a", Type: "vcs", Version: "*"},
+		{Name: "test/b", URL: "http://example.com/b", Type: "git"},
+	}
+	assert.NoError(t, satis.UpdateConfig(tmp.Name(), updates))
+
+	expected := `{"name":"repo","repositories":[{"type":"vcs","url":"http://example.com/a"},{"type":"git","url":"http://example.com/b"}],"require":{"test/a":"*"}}`
+	config, err := ioutil.ReadFile(tmp.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(config))
+}
+
+func TestUpdateConfigNoHTMLEscape(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "satis-test")
+	assert.NoError(t, err)
+	tmp.WriteString(`{"repositories":[]}`)
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	defer os.Remove(tmp.Name() + ".lock")
+
+	updates := []satis.PackageInfo{
+		{Name: "test/a", URL: "http://example.com/a?x=1&y=2", Type: "vcs", Version: ">=1.0 <2.0"},
+	}
+	assert.NoError(t, satis.UpdateConfig(tmp.Name(), updates))
+
+	expected := `{"repositories":[{"type":"vcs","url":"http://example.com/a?x=1&y=2"}],"require":{"test/a":">=1.0 <2.0"}}`
+	config, err := ioutil.ReadFile(tmp.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(config))
+}
+
+func TestUpdateConfigEscapes(t *testing.T) {
+	// as PHP json_encode writes it
+	content := `{
+    "name": "caf\u00e9",
+    "homepage": "https:\/\/example.com\/composer",
+    "repositories": [
+        { "type": "vcs", "url": "https:\/\/example.com\/test\/a.git" }
+    ],
+    "require": {
+        "test\/a": "*"
+    }
+}
+`
+	tmp, err := ioutil.TempFile("", "satis-test")
+	assert.NoError(t, err)
+	tmp.WriteString(content)
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	defer os.Remove(tmp.Name() + ".lock")
+
+	updates := []satis.PackageInfo{
+		{Name: "test/a", URL: "https://example.com/test/a.git", Type: "vcs", Version: "^1.0"},
+	}
+	assert.NoError(t, satis.UpdateConfig(tmp.Name(), updates))
+
+	expected := strings.Replace(content, `"test\/a": "*"`, `"test\/a": "^1.0"`, 1)
+	config, err := ioutil.ReadFile(tmp.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(config))
+
+	assert.NoError(t, satis.RemoveConfig(tmp.Name(), updates))
+	config, err = ioutil.ReadFile(tmp.Name())
+	assert.NoError(t, err)
+	assert.NotContains(t, string(config), "test\\/a")
+}
+
+func TestRemoveConfig(t *testing.T) {
+	content := `{
+    "name": "My Satis Repository",
+    "repositories": [
+        { "type": "vcs", "url": "git@example.com:test/a.git" },
+        { "type": "vcs", "url": "https://example.com/group/test/a.git" },
+        { "type": "vcs", "url": "http://example.com/b" },
+        { "type": "vcs", "url": "http://example.com/c" },
+        { "type": "vcs", "url": "ssh://git@example.com:2222/test/d.git/" }
+    ],
+    "require": {
+        "test/a": "*",
+        "test/b": "^1.0"
+    },
+    "require-all": false
+}
+`
+
+	tmp, err := ioutil.TempFile("", "satis-test")
+	assert.NoError(t, err)
+	tmp.WriteString(content)
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	defer os.Remove(tmp.Name() + ".lock")
+
+	removes := []satis.PackageInfo{
+		{Name: "test/a"},
+		{Name: "test/b", URL: "http://example.com/b"},
+		{Name: "test/d"},
+		{Name: "test/unknown"},
+	}
+	assert.NoError(t, satis.RemoveConfig(tmp.Name(), removes))
+
+	// the whole path after the host has to match the name
+	expected := `{
+    "name": "My Satis Repository",
+    "repositories": [
+        { "type": "vcs", "url": "https://example.com/group/test/a.git" },
+        { "type": "vcs", "url": "http://example.com/c" }
+    ],
+    "require": {},
+    "require-all": false
+}
+`
+	config, err := ioutil.ReadFile(tmp.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(config))
+}
+
+func TestConfigEntries(t *testing.T) {
+	content := `{
+    "name": "My Satis Repository",
+    "repositories": [
+        { "type": "vcs", "url": "http://example.com/a" }
+    ]
+}
+`
+
+	tmp, err := ioutil.TempFile("", "satis-test")
+	assert.NoError(t, err)
+	tmp.WriteString(content)
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	defer os.Remove(tmp.Name() + ".lock")
+
+	index, err := satis.AddRepository(tmp.Name(), map[string]interface{}{
+		"url":     "http://example.com/b",
+		"type":    "git",
+		"options": map[string]interface{}{"ssh2": map[string]interface{}{"username": "composer"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, index)
+
+	repo, err := satis.PatchRepository(tmp.Name(), 0, map[string]interface{}{"type": "git", "no-api": true})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"type": "git", "url": "http://example.com/a", "no-api": true}, repo)
+	_, err = satis.PatchRepository(tmp.Name(), 2, map[string]interface{}{"type": "git"})
+	assert.Equal(t, satis.ErrConfigEntryNotFound, errors.Cause(err))
+
+	assert.NoError(t, satis.SetRequire(tmp.Name(), "test/b", "^2.0"))
+	assert.NoError(t, satis.PatchConfig(tmp.Name(), map[string]interface{}{
+		"name":        "Packages",
+		"homepage":    "https://packages.example.com",
+		"require-all": false,
+	}))
+
+	expected := `{
+    "name": "Packages",
+    "repositories": [
+        { "type": "git", "url": "http://example.com/a", "no-api": true },
+        {
+            "type": "git",
+            "url": "http://example.com/b",
+            "options": {
+                "ssh2": {
+                    "username": "composer"
+                }
+            }
+        }
+    ],
+    "require": {
+        "test/b": "^2.0"
+    },
+    "homepage": "https://packages.example.com",
+    "require-all": false
+}
+`
+	config, err := ioutil.ReadFile(tmp.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(config))
+
+	repo, err = satis.DeleteRepository(tmp.Name(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com/a", repo["url"])
+	assert.NoError(t, satis.RemoveRequire(tmp.Name(), "test/b"))
+	assert.Equal(t, satis.ErrConfigEntryNotFound, errors.Cause(satis.RemoveRequire(tmp.Name(), "test/b")))
+
+	repos, err := satis.ConfigRepositories(tmp.Name())
+	assert.NoError(t, err)
+	assert.Len(t, repos, 1)
+	requires, err := satis.ConfigRequires(tmp.Name())
+	assert.NoError(t, err)
+	assert.Empty(t, requires)
+}
+
+func TestConfigEditValidation(t *testing.T) {
+	// the second repository is already broken
+	content := `{
+  "repositories": [
+    { "type": "vcs", "url": "http://example.com/a" },
+    { "type": "vcs" }
+  ]
+}`
+
+	tmp, err := ioutil.TempFile("", "satis-test")
+	assert.NoError(t, err)
+	tmp.WriteString(content)
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	defer os.Remove(tmp.Name() + ".lock")
+
+	_, err = satis.AddRepository(tmp.Name(), map[string]interface{}{"type": "vcs"})
+	if verr, ok := err.(*satis.ValidationError); assert.True(t, ok, "%v", err) {
+		assert.Equal(t, []satis.ConfigError{{Pointer: "/repositories/2/url", Message: "must be a non-empty string"}}, verr.Errors)
+	}
+
+	err = satis.PatchConfig(tmp.Name(), map[string]interface{}{"archive": map[string]interface{}{"format": "rar"}})
+	if verr, ok := err.(*satis.ValidationError); assert.True(t, ok, "%v", err) {
+		assert.Equal(t, []satis.ConfigError{
+			{Pointer: "/archive/directory", Message: "must be a non-empty string"},
+			{Pointer: "/archive/format", Message: `must be "zip" or "tar"`},
+		}, verr.Errors)
+	}
+
+	err = satis.PatchConfig(tmp.Name(), map[string]interface{}{"require": map[string]interface{}{}})
+	_, ok := err.(*satis.ValidationError)
+	assert.True(t, ok)
+
+	// the edits rejected above leave the file as it is
+	config, err := ioutil.ReadFile(tmp.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(config))
+
+	// the problem already there does not block the other edits
+	_, err = satis.PatchRepository(tmp.Name(), 0, map[string]interface{}{"type": "git"})
+	assert.NoError(t, err)
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		config   string
+		expected []satis.ConfigError
+	}{
+		{`{"name": "a", "homepage": "http://example.com"}`, nil},
+		{`[]`, []satis.ConfigError{{Pointer: "", Message: "must be an object"}}},
+		{`{"homepage": 1, "require-all": "yes", "minimum-stability": "rc"}`, []satis.ConfigError{
+			{Pointer: "/name", Message: "is required"},
+			{Pointer: "/homepage", Message: "must be a string"},
+			{Pointer: "/require-all", Message: "must be a boolean"},
+			{Pointer: "/minimum-stability", Message: "must be one of stable, RC, beta, alpha, dev"},
+		}},
+		{`{"name": "a", "homepage": "h", "repositories": [
+			{"type": "composer", "url": "https://packagist.org"},
+			{"packagist.org": false},
+			{"type": "path"},
+			{"type": "svn2", "url": "http://example.com/a"},
+			{"url": "http://example.com/b"},
+			{"type": "package", "package": [{"name": "Vendor/A", "version": "1.0.0", "dist": {"type": "zip"}}, {"name": "a"}]},
+			{"type": "vcs", "url": "--upload-pack=touch /tmp/pwned"}
+		]}`, []satis.ConfigError{
+			{Pointer: "/repositories/2/url", Message: "must be a non-empty string"},
+			{Pointer: "/repositories/3/type", Message: `unknown repository type "svn2"`},
+			{Pointer: "/repositories/4/type", Message: "must be a non-empty string"},
+			{Pointer: "/repositories/5/package/0/dist/url", Message: "must be a non-empty string"},
+			{Pointer: "/repositories/5/package/1/name", Message: "must be a package name"},
+			{Pointer: "/repositories/5/package/1/version", Message: "must be a non-empty string"},
+			{Pointer: "/repositories/6/url", Message: `must not start with "-"`},
+		}},
+		{`{"name": "a", "homepage": "h", "require": {"vendor/a": "", "vendor/a~b": "*"}, "blacklist": {"vendor/b": "*"}}`, []satis.ConfigError{
+			{Pointer: "/require/vendor~1a", Message: "must be a version constraint string"},
+			{Pointer: "/require/vendor~1a~0b", Message: "invalid package name"},
+		}},
+	}
+
+	for i, test := range tests {
+		err := satis.ValidateConfig([]byte(test.config))
+		if test.expected == nil {
+			assert.NoError(t, err, "#%d", i)
+			continue
+		}
+		if verr, ok := err.(*satis.ValidationError); assert.True(t, ok, "#%d: %v", i, err) {
+			assert.Equal(t, test.expected, verr.Errors, "#%d", i)
+		}
+	}
+
+	assert.Error(t, satis.ValidateConfig([]byte(`{"name": `)))
+}
@@ -0,0 +1,177 @@
+package satis
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around the changes.
+const diffContext = 3
+
+// maxDiffCells limits the size of the LCS table of diffLines. The lines
+// beyond it are shown as replaced as a whole.
+const maxDiffCells = 1 << 20
+
+// diffLine is a line of an edit script: ' ' kept, '-' deleted or '+' inserted.
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// unifiedDiff returns the changes from a to b in the unified format, or ""
+// when they are the same.
+func unifiedDiff(fromName, toName string, a, b []byte) string {
+	lines := diffLines(splitLines(a), splitLines(b))
+
+	var buf bytes.Buffer
+	for start := 0; start < len(lines); {
+		// find the next change
+		for start < len(lines) && lines[start].op == ' ' {
+			start++
+		}
+		if len(lines) <= start {
+			break
+		}
+
+		// extend the hunk while the changes are close enough
+		first := start - diffContext
+		if first < 0 {
+			first = 0
+		}
+		end := start
+		for kept := 0; end < len(lines) && kept <= 2*diffContext; end++ {
+			if lines[end].op == ' ' {
+				kept++
+			} else {
+				kept = 0
+			}
+		}
+		last := end
+		for last > start && lines[last-1].op == ' ' {
+			last--
+		}
+		last += diffContext
+		if len(lines) < last {
+			last = len(lines)
+		}
+
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		writeHunk(&buf, lines, first, last)
+		start = last
+	}
+	return buf.String()
+}
+
+// writeHunk writes lines[first:last] as a hunk.
+func writeHunk(buf *bytes.Buffer, lines []diffLine, first, last int) {
+	// line numbers of the hunk start in a and b
+	aLine, bLine := 1, 1
+	for _, l := range lines[:first] {
+		if l.op != '+' {
+			aLine++
+		}
+		if l.op != '-' {
+			bLine++
+		}
+	}
+	var aCount, bCount int
+	for _, l := range lines[first:last] {
+		if l.op != '+' {
+			aCount++
+		}
+		if l.op != '-' {
+			bCount++
+		}
+	}
+	if aCount == 0 {
+		aLine--
+	}
+	if bCount == 0 {
+		bLine--
+	}
+
+	fmt.Fprintf(buf, "@@ -%s +%s @@\n", hunkRange(aLine, aCount), hunkRange(bLine, bCount))
+	for _, l := range lines[first:last] {
+		buf.WriteByte(l.op)
+		buf.WriteString(l.text)
+		buf.WriteByte('\n')
+	}
+}
+
+func hunkRange(line, count int) string {
+	if count == 1 {
+		return fmt.Sprint(line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
+
+func splitLines(data []byte) []string {
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// diffLines returns the edit script from a to b based on their longest
+// common subsequence. The common head and tail are skipped first since
+// config changes are usually small.
+func diffLines(a, b []string) []diffLine {
+	var head, tail []diffLine
+	for 0 < len(a) && 0 < len(b) && a[0] == b[0] {
+		head = append(head, diffLine{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for 0 < len(a) && 0 < len(b) && a[len(a)-1] == b[len(b)-1] {
+		tail = append([]diffLine{{' ', a[len(a)-1]}}, tail...)
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	lines := head
+	if maxDiffCells < (len(a)+1)*(len(b)+1) {
+		for _, text := range a {
+			lines = append(lines, diffLine{'-', text})
+		}
+		for _, text := range b {
+			lines = append(lines, diffLine{'+', text})
+		}
+		return append(lines, tail...)
+	}
+
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; 0 <= i; i-- {
+		for j := len(b) - 1; 0 <= j; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	return append(lines, tail...)
+}
@@ -0,0 +1,50 @@
+package satis_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/reedom/satishub/pkg/satis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	var lines []string
+	for i := 1; i <= 20; i++ {
+		lines = append(lines, fmt.Sprintf("l%d", i))
+	}
+	text := strings.Join(lines, "\n") + "\n"
+	changed := strings.Replace(strings.Replace(text, "l2\n", "x\n", 1), "l18\n", "y\n", 1)
+
+	cases := []struct {
+		name     string
+		a, b     string
+		expected string
+	}{
+		{"unchanged", "a\nb\n", "a\nb\n", ""},
+		{"add only", "a\nb\n", "a\nb\nc\n", "--- from\n+++ to\n@@ -1,2 +1,3 @@\n a\n b\n+c\n"},
+		{"add to empty", "", "a\nb\n", "--- from\n+++ to\n@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"delete only", "a\nb\nc\n", "a\nc\n", "--- from\n+++ to\n@@ -1,3 +1,2 @@\n a\n-b\n c\n"},
+		{"delete all", "a\n", "", "--- from\n+++ to\n@@ -1 +0,0 @@\n-a\n"},
+		{"hunks with context", text, changed, "--- from\n+++ to\n" +
+			"@@ -1,5 +1,5 @@\n l1\n-l2\n+x\n l3\n l4\n l5\n" +
+			"@@ -15,6 +15,6 @@\n l15\n l16\n l17\n-l18\n+y\n l19\n l20\n"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, satis.UnifiedDiff("from", "to", []byte(c.a), []byte(c.b)), c.name)
+	}
+}
+
+func TestUnifiedDiffLarge(t *testing.T) {
+	// the inputs too large to compare line by line are replaced as a whole
+	var a, b bytes.Buffer
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&a, "a%d\n", i)
+		fmt.Fprintf(&b, "b%d\n", i)
+	}
+	diff := satis.UnifiedDiff("from", "to", a.Bytes(), b.Bytes())
+	assert.True(t, strings.HasPrefix(diff, "--- from\n+++ to\n@@ -1,2000 +1,2000 @@\n-a0\n"))
+	assert.Equal(t, 4003, strings.Count(diff, "\n"))
+}
@@ -0,0 +1,193 @@
+package satis
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+
+	"github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+// ErrConfigEntryNotFound is returned for a "repositories" index or a
+// "require" key which the satis config does not have.
+var ErrConfigEntryNotFound = errors.New("config entry not found")
+
+// listFields are the top-level fields edited entry by entry rather than
+// by PatchConfig.
+var listFields = []string{"repositories", "require"}
+
+// ReadConfig reads the satis config file.
+func ReadConfig(configPath string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, errors.Errorf("failed to open satis config file: %s", err.Error())
+	}
+
+	var config map[string]interface{}
+	if err = jsoniter.Unmarshal(data, &config); err != nil {
+		return nil, errors.Errorf("satis config file contains invalid JSON content: %s", err.Error())
+	}
+	return config, nil
+}
+
+// ConfigRepositories returns the "repositories" entries of the satis config.
+func ConfigRepositories(configPath string) ([]map[string]interface{}, error) {
+	config, err := ReadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return configReadRepos(config)
+}
+
+// ConfigRequires returns the "require" entries of the satis config.
+func ConfigRequires(configPath string) (map[string]interface{}, error) {
+	config, err := ReadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return configReadRequires(config)
+}
+
+// AddRepository appends the entry to "repositories" and returns its index.
+func AddRepository(configPath string, repo map[string]interface{}) (int, error) {
+	var index int
+	err := editConfig(configPath, func(c *configEdit) error {
+		index = len(c.repos)
+		c.repos = append(c.repos, repo)
+		if _, ok := c.config["repositories"]; ok {
+			return c.editor.append([]interface{}{"repositories"}, repositoryEntry(repo))
+		}
+		return c.editor.set(nil, "repositories", []repositoryEntry{repo})
+	})
+	return index, err
+}
+
+// PatchRepository merges the patch into the "repositories" entry at the
+// index and returns the entry. A null value in the patch removes the key,
+// and the others replace the values as a whole.
+func PatchRepository(configPath string, index int, patch map[string]interface{}) (map[string]interface{}, error) {
+	var repo map[string]interface{}
+	err := editConfig(configPath, func(c *configEdit) error {
+		if index < 0 || len(c.repos) <= index {
+			return ErrConfigEntryNotFound
+		}
+		repo = c.repos[index]
+		return patchObject(c.editor, []interface{}{"repositories", index}, repo, patch)
+	})
+	return repo, err
+}
+
+// DeleteRepository removes the "repositories" entry at the index and
+// returns the removed entry.
+func DeleteRepository(configPath string, index int) (map[string]interface{}, error) {
+	var repo map[string]interface{}
+	err := editConfig(configPath, func(c *configEdit) error {
+		if index < 0 || len(c.repos) <= index {
+			return ErrConfigEntryNotFound
+		}
+		repo = c.repos[index]
+		c.repos = append(c.repos[:index], c.repos[index+1:]...)
+		return c.editor.remove([]interface{}{"repositories"}, index)
+	})
+	return repo, err
+}
+
+// SetRequire sets the version constraint of the package in "require".
+func SetRequire(configPath, name, version string) error {
+	return editConfig(configPath, func(c *configEdit) error {
+		if c.requires[name] == version {
+			return nil
+		}
+		return c.setRequire(name, version)
+	})
+}
+
+// RemoveRequire removes the package from "require".
+func RemoveRequire(configPath, name string) error {
+	return editConfig(configPath, func(c *configEdit) error {
+		if _, ok := c.requires[name]; !ok {
+			return ErrConfigEntryNotFound
+		}
+		delete(c.requires, name)
+		return c.editor.remove([]interface{}{"require"}, name)
+	})
+}
+
+// PatchConfig merges the patch into the top-level fields of the satis
+// config, such as "name", "homepage", "require-all" and "archive". A null
+// value removes the field. "repositories" and "require" are not accepted
+// here; they are edited entry by entry.
+func PatchConfig(configPath string, patch map[string]interface{}) error {
+	var errs []ConfigError
+	for _, key := range listFields {
+		if _, ok := patch[key]; ok {
+			errs = append(errs, ConfigError{Pointer: jsonPointer(key), Message: "must be edited entry by entry"})
+		}
+	}
+	if 0 < len(errs) {
+		return &ValidationError{Errors: errs}
+	}
+
+	return editConfig(configPath, func(c *configEdit) error {
+		return patchObject(c.editor, nil, c.config, patch)
+	})
+}
+
+// patchObject applies the patch to the object at path, and to obj which is
+// its decoded copy.
+func patchObject(editor *jsonEditor, path []interface{}, obj, patch map[string]interface{}) error {
+	for _, key := range sortedKeys(patch) {
+		value := patch[key]
+		var err error
+		if value == nil {
+			delete(obj, key)
+			err = editor.remove(path, key)
+		} else {
+			obj[key] = value
+			err = editor.set(path, key, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repositoryEntry encodes a "repositories" entry with "type" and "url"
+// first, as satis documents them.
+type repositoryEntry map[string]interface{}
+
+func (r repositoryEntry) MarshalJSON() ([]byte, error) {
+	keys := make([]string, 0, len(r))
+	for key := range r {
+		if key != "type" && key != "url" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range []string{"url", "type"} {
+		if _, ok := r[key]; ok {
+			keys = append([]string{key}, keys...)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if 0 < i {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		value, err := json.Marshal(r[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
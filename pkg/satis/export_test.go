@@ -0,0 +1,58 @@
+package satis
+
+import (
+	"fmt"
+	"time"
+)
+
+// CronNext exposes cronSpec.next for tests.
+func CronNext(expr string, t time.Time) (time.Time, error) {
+	spec, err := parseCron(expr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return spec.next(t), nil
+}
+
+// RunSchedule runs the schedule right now for tests.
+func RunSchedule(s Service, schedule Schedule) []string {
+	return s.(*service).runSchedule(schedule)
+}
+
+// NormalizeVersion exposes normalizeVersion for tests.
+func NormalizeVersion(version string) (string, bool) {
+	return normalizeVersion(version)
+}
+
+// BranchVersion exposes branchVersion for tests.
+func BranchVersion(name string) (string, string) {
+	return branchVersion(name)
+}
+
+// JournalRecords queues the pending jobs and then n jobs which finish at
+// once into the queue journal at path, and returns the pending jobs read
+// back from the file.
+func JournalRecords(path string, pending []Job, n int) ([]Job, error) {
+	j, _, err := openJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range pending {
+		j.queued(job)
+	}
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("done-%d", i)
+		j.queued(Job{ID: id})
+		j.done(id)
+	}
+	j.Close()
+	return readJournal(path)
+}
+
+// JournalCompactRecords exposes journalCompactRecords for tests.
+var JournalCompactRecords = &journalCompactRecords
+
+// UnifiedDiff exposes unifiedDiff for tests.
+func UnifiedDiff(fromName, toName string, a, b []byte) string {
+	return unifiedDiff(fromName, toName, a, b)
+}
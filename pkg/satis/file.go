@@ -0,0 +1,75 @@
+package satis
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// writeData writes data to the temporary file. Tests replace it to simulate
+// a failure such as a full disk.
+var writeData = func(f *os.File, data []byte) (int, error) {
+	return f.Write(data)
+}
+
+// lockPath takes the advisory lock of the file at path. The lock is held on
+// a separate "<path>.lock" file since path itself gets replaced by rename.
+// The returned function releases the lock.
+func lockPath(path string) (func(), error) {
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Errorf("failed to open lock file: %s", err)
+	}
+	if err = lockFile(f); err != nil {
+		f.Close()
+		return nil, errors.Errorf("failed to lock %s: %s", path, err)
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and
+// renames it to path, so that path never holds a partially written content.
+// The mode of the existing file is kept.
+func writeFileAtomic(path string, data []byte, defaultMode os.FileMode) error {
+	mode := defaultMode
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	_, err = writeData(tmp, data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, mode)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// persist the rename as well; not every platform can sync a directory
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
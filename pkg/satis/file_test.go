@@ -0,0 +1,45 @@
+package satis
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateConfigWriteFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "satis-test")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "satis.json")
+	content := `{"repositories": []}`
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+	// the disk gets full in the middle of writing
+	defer func(orig func(*os.File, []byte) (int, error)) { writeData = orig }(writeData)
+	writeData = func(f *os.File, data []byte) (int, error) {
+		n, _ := f.Write(data[:len(data)/2])
+		return n, errors.New("no space left on device")
+	}
+
+	updates := []PackageInfo{{Name: "test/pkg", URL: "http://example.com/pkg", Type: "vcs"}}
+	err = UpdateConfig(path, updates)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "no space left on device")
+	}
+
+	// the config stays intact and no temporary file is left
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(data))
+	files, _ := ioutil.ReadDir(dir)
+	for _, fi := range files {
+		assert.Contains(t, []string{"satis.json", "satis.json.lock"}, fi.Name())
+	}
+}
@@ -0,0 +1,320 @@
+package satis
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrGenerationNotFound is returned for an output generation which is not kept.
+var ErrGenerationNotFound = errors.New("generation not found")
+
+// ErrGenerationsDisabled is returned when the output generations are not kept.
+var ErrGenerationsDisabled = errors.New("output generations disabled")
+
+// Generation is a satis output directory made by a full rebuild.
+type Generation struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	// Current tells whether the output directory path points to it.
+	Current bool `json:"current"`
+}
+
+// generationTimeFormat is the format of the generation IDs, which sort
+// them in time order.
+const generationTimeFormat = "20060102-150405.000000"
+
+// generations keeps the satis output directories of the full rebuilds in
+// dir. The output directory path, link, is a symbolic link to one of them,
+// which is switched atomically.
+type generations struct {
+	dir  string
+	link string
+	// limit is the number of generations to be kept besides the current one.
+	limit int
+}
+
+// openGenerations opens the generations in dir, creating it if necessary.
+// A plain directory at link becomes the first generation.
+func openGenerations(link, dir string, limit int) (*generations, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, errors.Errorf("failed to open output generations: %s", err)
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Errorf("failed to open output generations: %s", err)
+	}
+	g := &generations{dir: dir, link: link, limit: limit}
+
+	info, err := os.Lstat(link)
+	switch {
+	case os.IsNotExist(err):
+		return g, nil
+	case err != nil:
+		return nil, errors.Errorf("failed to open output generations: %s", err)
+	case info.Mode()&os.ModeSymlink != 0:
+		return g, nil
+	case !info.IsDir():
+		return nil, errors.Errorf("failed to open output generations: %s is not a directory", link)
+	}
+
+	id := newGenerationID(info.ModTime())
+	if err = os.Rename(link, filepath.Join(dir, id)); err != nil {
+		return nil, errors.Errorf("failed to move the output directory into generations: %s", err)
+	}
+	return g, g.activate(id)
+}
+
+// list returns the generations, the newest first.
+func (g *generations) list() ([]Generation, error) {
+	infos, err := ioutil.ReadDir(g.dir)
+	if err != nil {
+		return nil, errors.Errorf("failed to read output generations: %s", err)
+	}
+	current := g.current()
+
+	var gens []Generation
+	for i := len(infos) - 1; 0 <= i; i-- {
+		name := infos[i].Name()
+		if !infos[i].IsDir() {
+			continue
+		}
+		t, err := time.ParseInLocation(generationTimeFormat, name, time.UTC)
+		if err != nil {
+			continue
+		}
+		gens = append(gens, Generation{ID: name, Time: t, Current: name == current})
+	}
+	return gens, nil
+}
+
+// find returns the generation of the ID.
+func (g *generations) find(id string) (Generation, error) {
+	gens, err := g.list()
+	if err != nil {
+		return Generation{}, err
+	}
+	for _, gen := range gens {
+		if gen.ID == id {
+			return gen, nil
+		}
+	}
+	return Generation{}, ErrGenerationNotFound
+}
+
+// current returns the ID of the generation which link points to.
+func (g *generations) current() string {
+	target, err := os.Readlink(g.link)
+	if err != nil || filepath.Dir(target) != g.dir {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// stage makes a staging directory for a new generation, filled with the
+// current one so that satis finds the archives it has made in archiveDir.
+// It returns the ID and the directory.
+func (g *generations) stage(archiveDir string) (string, string, error) {
+	id := newGenerationID(time.Now())
+	dir := g.stagingPath(id)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", "", errors.Errorf("failed to make a staging directory: %s", err)
+	}
+	if current := g.current(); current != "" {
+		if err := copyTree(filepath.Join(g.dir, current), dir, archiveDir); err != nil {
+			os.RemoveAll(dir)
+			return "", "", errors.Errorf("failed to make a staging directory: %s", err)
+		}
+	}
+	return id, dir, nil
+}
+
+// commit turns the staging directory into the current generation, and
+// removes the old generations beyond the limit.
+func (g *generations) commit(id string) error {
+	if err := os.Rename(g.stagingPath(id), filepath.Join(g.dir, id)); err != nil {
+		g.discard(id)
+		return errors.Errorf("failed to save generation %s: %s", id, err)
+	}
+	if err := g.activate(id); err != nil {
+		return err
+	}
+	g.prune()
+	return nil
+}
+
+// discard removes the staging directory.
+func (g *generations) discard(id string) {
+	os.RemoveAll(g.stagingPath(id))
+}
+
+// activate points link to the generation. The link is replaced by a rename
+// so that the readers never find it missing.
+func (g *generations) activate(id string) error {
+	tmp := g.link + ".next"
+	os.Remove(tmp)
+	if err := os.Symlink(filepath.Join(g.dir, id), tmp); err != nil {
+		return errors.Errorf("failed to switch to generation %s: %s", id, err)
+	}
+	if err := os.Rename(tmp, g.link); err != nil {
+		os.Remove(tmp)
+		return errors.Errorf("failed to switch to generation %s: %s", id, err)
+	}
+	return nil
+}
+
+// prune removes the generations older than the newest ones up to the limit,
+// except the current one.
+func (g *generations) prune() {
+	gens, err := g.list()
+	if err != nil {
+		return
+	}
+	for i, gen := range gens {
+		if g.limit <= i && !gen.Current {
+			os.RemoveAll(filepath.Join(g.dir, gen.ID))
+		}
+	}
+}
+
+// stagingPath returns the directory in which the generation is built.
+// The leading dot keeps it out of the list.
+func (g *generations) stagingPath(id string) string {
+	return filepath.Join(g.dir, "."+id)
+}
+
+func newGenerationID(t time.Time) string {
+	return t.UTC().Format(generationTimeFormat)
+}
+
+// archiveDirectory returns the archive directory of the satis config relative
+// to the output directory, or "dist" when the config does not tell.
+func (s *service) archiveDirectory() string {
+	config, err := ReadConfig(s.configPath)
+	if err != nil {
+		return "dist"
+	}
+	archive, _ := config["archive"].(map[string]interface{})
+	if directory, _ := archive["directory"].(string); directory != "" {
+		return directory
+	}
+	return "dist"
+}
+
+// copyTree copies the directory tree at src into dst. The archives under
+// archiveDir are hard linked when possible since satis never rewrites them,
+// while it rewrites the other files in place.
+func copyTree(src, dst, archiveDir string) error {
+	archivePrefix := strings.Trim(filepath.ToSlash(filepath.Clean(archiveDir)), "/") + "/"
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.Mkdir(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case !info.Mode().IsRegular():
+			return nil
+		}
+		if strings.HasPrefix(filepath.ToSlash(rel), archivePrefix) && os.Link(path, target) == nil {
+			return nil
+		}
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Generations returns the output generations, the newest first.
+func (s *service) Generations() ([]Generation, error) {
+	if s.generations == nil {
+		return nil, ErrGenerationsDisabled
+	}
+	return s.generations.list()
+}
+
+// SwitchGeneration requests pointing the output directory path to the
+// generation. It runs alone, like a full rebuild.
+func (s *service) SwitchGeneration(id string) (string, chan ServiceResult, error) {
+	if s.generations == nil {
+		return "", nil, ErrGenerationsDisabled
+	}
+	if _, err := s.generations.find(id); err != nil {
+		return "", nil, err
+	}
+
+	job := s.jobs.addJob(Job{Kind: JobKindSwitch, Generation: id})
+	s.journalQueued(job)
+	ch := make(chan ServiceResult, 1)
+	select {
+	case s.cmdRebuild <- requestRebuild{JobID: job.ID, Result: ch, Switch: id}:
+		return job.ID, ch, nil
+	default:
+		s.rejectFull(job.ID)
+		return "", nil, ErrQueueFull
+	}
+}
+
+// switchGeneration points the output directory path to the generation.
+func (s *service) switchGeneration(req requestRebuild) error {
+	if s.generations == nil {
+		return ErrGenerationsDisabled
+	}
+	if _, err := s.generations.find(req.Switch); err != nil {
+		return err
+	}
+	if err := s.generations.activate(req.Switch); err != nil {
+		return err
+	}
+	fmt.Fprintf(s.jobs.output(req.JobID), "switched to generation %s\n", req.Switch)
+	return nil
+}
+
+// buildGeneration runs a full rebuild into a new generation, and switches
+// to it when the build succeeds.
+func (s *service) buildGeneration(ctx context.Context, out io.Writer) error {
+	id, dir, err := s.generations.stage(s.archiveDirectory())
+	if err != nil {
+		return err
+	}
+	if err = s.runBuilder(ctx, dir, nil, out); err != nil {
+		s.generations.discard(id)
+		return err
+	}
+	return s.generations.commit(id)
+}
@@ -0,0 +1,203 @@
+package satis
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// gitMirror is a bare mirror of a git repository, kept across the builds so
+// that only the new commits are fetched.
+type gitMirror struct {
+	gitPath string
+	dir     string
+}
+
+// gitRef is a branch or a tag of a git repository.
+type gitRef struct {
+	// Name is the short name, such as "master" or "v1.0.0".
+	Name   string
+	Tag    bool
+	Commit string
+	Time   time.Time
+}
+
+// newGitMirror returns the mirror of the repository at url in mirrorPath.
+func newGitMirror(gitPath, mirrorPath, url string) *gitMirror {
+	sum := sha1.Sum([]byte(url))
+	return &gitMirror{gitPath: gitPath, dir: filepath.Join(mirrorPath, hex.EncodeToString(sum[:])+".git")}
+}
+
+// exists determines whether the mirror has been cloned.
+func (m *gitMirror) exists() bool {
+	_, err := os.Stat(m.dir)
+	return err == nil
+}
+
+// update fetches the repository at url into the mirror, cloning it first
+// if necessary.
+func (m *gitMirror) update(ctx context.Context, url string, out io.Writer) error {
+	if err := os.MkdirAll(filepath.Dir(m.dir), 0755); err != nil {
+		return errors.Errorf("failed to make mirror directory: %s", err)
+	}
+	unlock, err := lockPath(m.dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if m.exists() {
+		_, err = m.run(ctx, out, nil, "fetch", "--prune", "--quiet", "origin")
+		return err
+	}
+	if _, err = m.run(ctx, out, nil, "clone", "--mirror", "--quiet", "--", url, m.dir); err != nil {
+		os.RemoveAll(m.dir)
+		return err
+	}
+	return nil
+}
+
+// head returns the name of the default branch.
+func (m *gitMirror) head(ctx context.Context) (string, error) {
+	data, err := m.run(ctx, nil, nil, "symbolic-ref", "--quiet", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(strings.TrimSpace(string(data)), "refs/heads/"), nil
+}
+
+// refs returns the branches and the tags.
+func (m *gitMirror) refs(ctx context.Context) ([]gitRef, error) {
+	// an annotated tag refers to the commit by "*objectname"
+	format := "%(refname)%09%(objectname)%09%(committerdate:raw)%09%(*objectname)%09%(*committerdate:raw)"
+	data, err := m.run(ctx, nil, nil, "for-each-ref", "--format="+format, "refs/heads", "refs/tags")
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []gitRef
+	// a lightweight tag ends with the empty fields, which must be kept
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 5 {
+			continue
+		}
+		ref := gitRef{Commit: fields[1], Time: rawGitTime(fields[2])}
+		if fields[3] != "" {
+			ref.Commit, ref.Time = fields[3], rawGitTime(fields[4])
+		}
+		switch {
+		case strings.HasPrefix(fields[0], "refs/heads/"):
+			ref.Name = strings.TrimPrefix(fields[0], "refs/heads/")
+		case strings.HasPrefix(fields[0], "refs/tags/"):
+			ref.Name, ref.Tag = strings.TrimPrefix(fields[0], "refs/tags/"), true
+		default:
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// files returns the content of the file at path in each of the commits, or
+// nil for the commits which do not have it.
+func (m *gitMirror) files(ctx context.Context, commits []string, path string) ([][]byte, error) {
+	var input bytes.Buffer
+	for _, commit := range commits {
+		input.WriteString(commit + ":" + path + "\n")
+	}
+	data, err := m.run(ctx, nil, input.Bytes(), "cat-file", "--batch")
+	if err != nil {
+		return nil, err
+	}
+
+	// each object is "<sha> <type> <size>\n<content>\n", or "<name> missing\n"
+	files := make([][]byte, len(commits))
+	r := bufio.NewReader(bytes.NewReader(data))
+	for i := range commits {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, errors.Errorf("failed to read %s: unexpected git output", path)
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			continue
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, errors.Errorf("failed to read %s: unexpected git output", path)
+		}
+		content := make([]byte, size+1)
+		if _, err = io.ReadFull(r, content); err != nil {
+			return nil, errors.Errorf("failed to read %s: unexpected git output", path)
+		}
+		if fields[1] == "blob" {
+			files[i] = content[:size]
+		}
+	}
+	return files, nil
+}
+
+// archive writes the tree of the commit into the archive file at path in
+// the format, "zip" or "tar".
+func (m *gitMirror) archive(ctx context.Context, commit, format, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Errorf("failed to make archive directory: %s", err)
+	}
+	tmp := path + ".tmp"
+	if _, err := m.run(ctx, nil, nil, "archive", "--format="+format, "--output="+tmp, commit); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return errors.Errorf("failed to write archive: %s", err)
+	}
+	return nil
+}
+
+// run runs git in the mirror and returns its output. The error output goes
+// to out as well, when it is given.
+func (m *gitMirror) run(ctx context.Context, out io.Writer, stdin []byte, args ...string) ([]byte, error) {
+	command := exec.CommandContext(ctx, m.gitPath, args...)
+	if args[0] != "clone" {
+		command.Dir = m.dir
+	}
+	// fail rather than wait for the credentials
+	command.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if stdin != nil {
+		command.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	command.Stderr = &stderr
+	if out != nil {
+		command.Stderr = io.MultiWriter(&stderr, out)
+	}
+
+	data, err := command.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "git %s failed: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+	return data, nil
+}
+
+// rawGitTime parses the time in the git raw format, "<unix time> <zone>".
+func rawGitTime(raw string) time.Time {
+	fields := strings.Fields(raw)
+	if len(fields) == 0 {
+		return time.Time{}
+	}
+	sec, _ := strconv.ParseInt(fields[0], 10, 64)
+	return time.Unix(sec, 0).UTC()
+}
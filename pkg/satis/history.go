@@ -0,0 +1,299 @@
+package satis
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrRevisionNotFound is returned for a config revision which the history
+// does not have.
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// ErrHistoryDisabled is returned when the config history is not kept.
+var ErrHistoryDisabled = errors.New("config history disabled")
+
+// Revision is a saved state of the satis config.
+type Revision struct {
+	Number int       `json:"number"`
+	Time   time.Time `json:"time"`
+	// JobIDs are the jobs which made the change.
+	JobIDs []string `json:"job_ids,omitempty"`
+	// Source tells what made the change, such as the webhook path,
+	// "rollback to revision 3" or "external" for an edit by hand.
+	Source string `json:"source"`
+}
+
+// historyIndex is the file listing the revisions, one JSON per line. The
+// config text of each revision is kept in "<number>.json".
+const historyIndex = "revisions.jsonl"
+
+// ConfigHistory keeps the revisions of the satis config in a directory.
+// The directory may be shared with other processes, such as the CLI.
+type ConfigHistory struct {
+	dir string
+	// limit is the number of revisions to be kept. Zero means no limit.
+	limit int
+}
+
+// OpenConfigHistory opens the config history in the directory, creating it
+// if necessary.
+func OpenConfigHistory(dir string, limit int) (*ConfigHistory, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Errorf("failed to open config history: %s", err)
+	}
+	return &ConfigHistory{dir: dir, limit: limit}, nil
+}
+
+// Revisions returns the revisions, the newest first.
+func (h *ConfigHistory) Revisions() ([]Revision, error) {
+	revs, err := h.read()
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(revs)-1; i < j; i, j = i+1, j-1 {
+		revs[i], revs[j] = revs[j], revs[i]
+	}
+	return revs, nil
+}
+
+// Revision returns the revision and its config text.
+func (h *ConfigHistory) Revision(number int) (Revision, []byte, error) {
+	revs, err := h.read()
+	if err != nil {
+		return Revision{}, nil, err
+	}
+	for _, rev := range revs {
+		if rev.Number != number {
+			continue
+		}
+		data, err := ioutil.ReadFile(h.revisionPath(number))
+		if err != nil {
+			return Revision{}, nil, errors.Errorf("failed to read revision %d: %s", number, err)
+		}
+		return rev, data, nil
+	}
+	return Revision{}, nil, ErrRevisionNotFound
+}
+
+// Diff returns the changes from a revision to another in the unified format.
+// A zero number means the latest revision.
+func (h *ConfigHistory) Diff(from, to int) (string, error) {
+	if from == 0 || to == 0 {
+		revs, err := h.read()
+		if err != nil {
+			return "", err
+		}
+		if len(revs) == 0 {
+			return "", ErrRevisionNotFound
+		}
+		if from == 0 {
+			from = revs[len(revs)-1].Number
+		}
+		if to == 0 {
+			to = revs[len(revs)-1].Number
+		}
+	}
+
+	_, a, err := h.Revision(from)
+	if err != nil {
+		return "", err
+	}
+	_, b, err := h.Revision(to)
+	if err != nil {
+		return "", err
+	}
+	return unifiedDiff(fmt.Sprintf("revision %d", from), fmt.Sprintf("revision %d", to), a, b), nil
+}
+
+// record saves the config text as a new revision unless it is the same as
+// the latest one. It returns the new revision, or nil.
+func (h *ConfigHistory) record(data []byte, jobIDs []string, source string) (*Revision, error) {
+	unlock, err := lockPath(filepath.Join(h.dir, historyIndex))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	revs, err := h.read()
+	if err != nil {
+		return nil, err
+	}
+	rev := Revision{Number: 1, Time: time.Now(), JobIDs: jobIDs, Source: source}
+	if 0 < len(revs) {
+		latest := revs[len(revs)-1]
+		if prev, err := ioutil.ReadFile(h.revisionPath(latest.Number)); err == nil && bytes.Equal(prev, data) {
+			return nil, nil
+		}
+		rev.Number = latest.Number + 1
+	}
+
+	if err = writeFileAtomic(h.revisionPath(rev.Number), data, 0644); err != nil {
+		return nil, errors.Errorf("failed to write revision %d: %s", rev.Number, err)
+	}
+	revs = append(revs, rev)
+	var pruned []Revision
+	if 0 < h.limit && h.limit < len(revs) {
+		pruned = revs[:len(revs)-h.limit]
+		revs = revs[len(revs)-h.limit:]
+	}
+
+	var buf bytes.Buffer
+	for _, r := range revs {
+		line, _ := json.Marshal(r)
+		buf.Write(append(line, '\n'))
+	}
+	if err = writeFileAtomic(filepath.Join(h.dir, historyIndex), buf.Bytes(), 0644); err != nil {
+		return nil, errors.Errorf("failed to write config history: %s", err)
+	}
+	for _, r := range pruned {
+		os.Remove(h.revisionPath(r.Number))
+	}
+	return &rev, nil
+}
+
+// read returns the revisions in the index, the oldest first.
+func (h *ConfigHistory) read() ([]Revision, error) {
+	f, err := os.Open(filepath.Join(h.dir, historyIndex))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Errorf("failed to read config history: %s", err)
+	}
+	defer f.Close()
+
+	var revs []Revision
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var rev Revision
+		if err := json.Unmarshal(scanner.Bytes(), &rev); err != nil {
+			continue
+		}
+		revs = append(revs, rev)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Errorf("failed to read config history: %s", err)
+	}
+	return revs, nil
+}
+
+func (h *ConfigHistory) revisionPath(number int) string {
+	return filepath.Join(h.dir, strconv.Itoa(number)+".json")
+}
+
+// History returns the config history, or nil when it is not kept.
+func (s *service) History() *ConfigHistory {
+	return s.history
+}
+
+// EditConfig runs fn, which edits the satis config at configPath, and
+// records the change in the config history as made by source.
+func (s *service) EditConfig(source string, fn func(configPath string) error) error {
+	return s.changeConfig(nil, source, func() error {
+		return fn(s.configPath)
+	})
+}
+
+// changeConfig runs fn, which edits the satis config, exclusively with the
+// other edits by the service. The change is recorded in the config history,
+// after a change made outside of the service since the last revision.
+func (s *service) changeConfig(jobIDs []string, source string, fn func() error) error {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	s.recordConfig(nil, "external")
+	err := fn()
+	s.recordConfig(jobIDs, source)
+	return err
+}
+
+// recordConfig saves the current satis config as a revision if it has changed.
+func (s *service) recordConfig(jobIDs []string, source string) {
+	if s.history == nil {
+		return
+	}
+	data, err := ioutil.ReadFile(s.configPath)
+	if err != nil {
+		return
+	}
+	rev, err := s.history.record(data, jobIDs, source)
+	if err != nil {
+		s.errLog.Println(err.Error())
+	} else if rev != nil && s.debug {
+		s.stdLog.Printf("config revision %d: %s", rev.Number, source)
+	}
+}
+
+// Rollback requests restoring the satis config of the revision, followed
+// by a full rebuild.
+func (s *service) Rollback(number int) (string, chan ServiceResult, error) {
+	if s.history == nil {
+		return "", nil, ErrHistoryDisabled
+	}
+	if _, _, err := s.history.Revision(number); err != nil {
+		return "", nil, err
+	}
+
+	job := s.jobs.addJob(Job{Kind: JobKindRollback, Revision: number})
+	s.journalQueued(job)
+	ch := make(chan ServiceResult, 1)
+	select {
+	case s.cmdRebuild <- requestRebuild{JobID: job.ID, Result: ch, Rollback: number}:
+		return job.ID, ch, nil
+	default:
+		s.rejectFull(job.ID)
+		return "", nil, ErrQueueFull
+	}
+}
+
+// rollbackConfig restores the satis config of the revision and rebuilds
+// the repository.
+func (s *service) rollbackConfig(ctx context.Context, req requestRebuild) error {
+	if s.history == nil {
+		return ErrHistoryDisabled
+	}
+	source := fmt.Sprintf("rollback to revision %d", req.Rollback)
+	err := s.changeConfig([]string{req.JobID}, source, func() error {
+		_, data, err := s.history.Revision(req.Rollback)
+		if err != nil {
+			return err
+		}
+		return restoreConfig(s.configPath, data)
+	})
+	if err != nil {
+		return err
+	}
+
+	out := s.jobs.output(req.JobID)
+	return s.withRetry(ctx, []string{req.JobID}, out, func(ctx context.Context) error {
+		return s.rebuild(ctx, out)
+	})
+}
+
+// packageSources returns the sources of the package requests for the
+// config history, or def when none is known.
+func packageSources(pkgs []PackageInfo, def string) string {
+	var sources []string
+	seen := make(map[string]bool)
+	for _, pkg := range pkgs {
+		if pkg.Source != "" && !seen[pkg.Source] {
+			seen[pkg.Source] = true
+			sources = append(sources, pkg.Source)
+		}
+	}
+	if len(sources) == 0 {
+		return def
+	}
+	return strings.Join(sources, ", ")
+}
@@ -0,0 +1,274 @@
+package satis
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ComposerIndex holds the Composer repository metadata in the satis output
+// directory in memory, to serve it with the validators for the conditional
+// requests. packages.json is served with "metadata-url",
+// "available-packages" and "providers-api" which point to the "p2" files
+// and the providers made from their "provide".
+type ComposerIndex struct {
+	repoPath   string
+	configPath string
+	// reloadMu serializes the reloads so that the last one wins.
+	reloadMu sync.Mutex
+
+	mu        sync.RWMutex
+	root      *IndexFile
+	metadata  map[string]*IndexFile
+	providers map[string]*IndexFile
+}
+
+// IndexFile is a file served from the index.
+type IndexFile struct {
+	Data         []byte
+	ETag         string
+	LastModified time.Time
+}
+
+// composerProvider is a package which provides another one.
+type composerProvider struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+}
+
+// NewComposerIndex returns an empty index of the satis output directory.
+func NewComposerIndex(repoPath, configPath string) *ComposerIndex {
+	return &ComposerIndex{repoPath: repoPath, configPath: configPath}
+}
+
+// PackagesJSON returns packages.json.
+func (x *ComposerIndex) PackagesJSON() (*IndexFile, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.root, x.root != nil
+}
+
+// Metadata returns the "p2" file of the package, such as "vendor/name" or
+// "vendor/name~dev".
+func (x *ComposerIndex) Metadata(name string) (*IndexFile, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	file, ok := x.metadata[name]
+	return file, ok
+}
+
+// Providers returns the packages which provide the package, in the format
+// of the "providers-api" responses.
+func (x *ComposerIndex) Providers(name string) (*IndexFile, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.root == nil {
+		return nil, false
+	}
+	if file, ok := x.providers[name]; ok {
+		return file, true
+	}
+	return x.providers[""], true
+}
+
+// Reload reads the satis output directory again. The files which have not
+// changed keep their Last-Modified.
+func (x *ComposerIndex) Reload() error {
+	x.reloadMu.Lock()
+	defer x.reloadMu.Unlock()
+
+	data, err := ioutil.ReadFile(filepath.Join(x.repoPath, "packages.json"))
+	if os.IsNotExist(err) {
+		x.mu.Lock()
+		x.root, x.metadata, x.providers = nil, nil, nil
+		x.mu.Unlock()
+		return nil
+	} else if err != nil {
+		return errors.Errorf("failed to load packages.json: %s", err)
+	}
+	var root map[string]interface{}
+	if err = json.Unmarshal(data, &root); err != nil {
+		return errors.Errorf("failed to load packages.json: %s", err)
+	}
+
+	x.mu.RLock()
+	prev := x.metadata
+	x.mu.RUnlock()
+
+	metadata := make(map[string]*IndexFile)
+	provided := make(map[string][]composerProvider)
+	p2 := filepath.Join(x.repoPath, "p2")
+	err = filepath.Walk(p2, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == p2 && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		rel, err := filepath.Rel(p2, path)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.ToSlash(rel), ".json")
+		metadata[name] = newIndexFile(prev[name], data, info.ModTime())
+		collectProviders(provided, data)
+		return nil
+	})
+	if err != nil {
+		return errors.Errorf("failed to load p2 metadata: %s", err)
+	}
+
+	x.mu.RLock()
+	prevRoot, prevProviders := x.root, x.providers
+	x.mu.RUnlock()
+
+	now := time.Now()
+	if 0 < len(metadata) {
+		x.complementRoot(root, metadata)
+	}
+	data, _ = json.MarshalIndent(root, "", "    ")
+	rootFile := newIndexFile(prevRoot, append(data, '\n'), now)
+
+	providers := make(map[string]*IndexFile, len(provided)+1)
+	providers[""] = newIndexFile(prevProviders[""], []byte(`{"providers":[]}`), now)
+	for name, list := range provided {
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		data, _ := json.Marshal(map[string][]composerProvider{"providers": list})
+		providers[name] = newIndexFile(prevProviders[name], data, now)
+	}
+
+	x.mu.Lock()
+	x.root, x.metadata, x.providers = rootFile, metadata, providers
+	x.mu.Unlock()
+	return nil
+}
+
+// complementRoot sets "metadata-url", "available-packages" and
+// "providers-api" of packages.json. The "metadata-url" satis has written
+// is kept, and the others are located next to it.
+func (x *ComposerIndex) complementRoot(root map[string]interface{}, metadata map[string]*IndexFile) {
+	metadataURL, _ := root["metadata-url"].(string)
+	if metadataURL == "" {
+		config, _ := ReadConfig(x.configPath)
+		metadataURL = repositoryPrefix(config) + "/p2/%package%.json"
+		root["metadata-url"] = metadataURL
+	}
+	if strings.HasSuffix(metadataURL, "p2/%package%.json") {
+		root["providers-api"] = strings.TrimSuffix(metadataURL, "p2/%package%.json") + "providers/%package%.json"
+	}
+
+	var names []string
+	for name := range metadata {
+		name = strings.TrimSuffix(name, "~dev")
+		if !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	root["available-packages"] = names
+}
+
+// collectProviders adds the package in the "p2" file to the providers of
+// the packages its versions provide.
+func collectProviders(provided map[string][]composerProvider, data []byte) {
+	var metadata struct {
+		Packages map[string][]map[string]interface{} `json:"packages"`
+		Minified string                              `json:"minified"`
+	}
+	if json.Unmarshal(data, &metadata) != nil {
+		return
+	}
+	for name, versions := range metadata.Packages {
+		if metadata.Minified == "composer/2.0" {
+			versions = expandVersions(versions)
+		}
+		for _, v := range versions {
+			provide, _ := v["provide"].(map[string]interface{})
+			for target := range provide {
+				target = strings.ToLower(target)
+				if target == name || hasProvider(provided[target], name) {
+					continue
+				}
+				p := composerProvider{Name: name}
+				p.Description, _ = v["description"].(string)
+				p.Type, _ = v["type"].(string)
+				provided[target] = append(provided[target], p)
+			}
+		}
+	}
+}
+
+func hasProvider(providers []composerProvider, name string) bool {
+	for _, p := range providers {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// expandVersions restores the versions of the "composer/2.0" minified
+// metadata, where each version holds the changes from the previous one.
+func expandVersions(versions []map[string]interface{}) []map[string]interface{} {
+	expanded := make([]map[string]interface{}, len(versions))
+	var last map[string]interface{}
+	for i, v := range versions {
+		current := make(map[string]interface{}, len(last)+len(v))
+		for key, value := range last {
+			current[key] = value
+		}
+		for key, value := range v {
+			if value == "__unset" {
+				delete(current, key)
+			} else {
+				current[key] = value
+			}
+		}
+		expanded[i], last = current, current
+	}
+	return expanded
+}
+
+// newIndexFile returns the file of the data, or prev when it has the same
+// data so that the Last-Modified is kept.
+func newIndexFile(prev *IndexFile, data []byte, modTime time.Time) *IndexFile {
+	sum := sha1.Sum(data)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	if prev != nil && prev.ETag == etag {
+		return prev
+	}
+	return &IndexFile{Data: data, ETag: etag, LastModified: modTime.UTC().Truncate(time.Second)}
+}
+
+// Index returns the Composer index of the satis output directory, or nil
+// when the files are served as they are.
+func (s *service) Index() *ComposerIndex {
+	return s.index
+}
+
+// reloadIndex reloads the Composer index after a successful build.
+func (s *service) reloadIndex() {
+	if s.index == nil {
+		return
+	}
+	if err := s.index.Reload(); err != nil {
+		s.errLog.Println(err.Error())
+	}
+}
@@ -0,0 +1,127 @@
+package satis_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/reedom/satishub/pkg/satis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComposerIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "satis-index")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "satis.json")
+	ioutil.WriteFile(configPath, []byte(`{"name":"a","homepage":"https://example.com/composer/"}`), 0644)
+	repo := filepath.Join(dir, "repo")
+	os.MkdirAll(filepath.Join(repo, "p2", "a"), 0755)
+
+	x := satis.NewComposerIndex(repo, configPath)
+	assert.NoError(t, x.Reload())
+	_, ok := x.PackagesJSON()
+	assert.False(t, ok)
+	_, ok = x.Providers("c/d")
+	assert.False(t, ok)
+
+	// the versions of the minified metadata inherit "provide"
+	ioutil.WriteFile(filepath.Join(repo, "packages.json"), []byte(`{"packages":[]}`), 0644)
+	ioutil.WriteFile(filepath.Join(repo, "p2", "a", "b.json"), []byte(`{"minified":"composer/2.0","packages":{"a/b":[
+		{"name":"a/b","version":"2.0.0","description":"B","type":"library","provide":{"c/d":"2.0"}},
+		{"version":"1.0.0"},
+		{"version":"0.1.0","provide":"__unset"}
+	]}}`), 0644)
+	ioutil.WriteFile(filepath.Join(repo, "p2", "a", "e~dev.json"), []byte(`{"packages":{"a/e":[
+		{"name":"a/e","version":"dev-master","provide":{"C/D":"*","a/e-impl":"*"}}
+	]}}`), 0644)
+	assert.NoError(t, x.Reload())
+
+	root, ok := x.PackagesJSON()
+	if !assert.True(t, ok) {
+		t.FailNow()
+	}
+	assert.JSONEq(t, `{
+		"packages": [],
+		"metadata-url": "/composer/p2/%package%.json",
+		"providers-api": "/composer/providers/%package%.json",
+		"available-packages": ["a/b", "a/e"]
+	}`, string(root.Data))
+
+	file, ok := x.Metadata("a/e~dev")
+	assert.True(t, ok)
+	assert.NotEmpty(t, file.ETag)
+	_, ok = x.Metadata("a/e")
+	assert.False(t, ok)
+
+	file, ok = x.Providers("c/d")
+	assert.True(t, ok)
+	assert.JSONEq(t, `{"providers":[
+		{"name":"a/b","description":"B","type":"library"},
+		{"name":"a/e","description":"","type":""}
+	]}`, string(file.Data))
+	file, _ = x.Providers("a/e-impl")
+	assert.JSONEq(t, `{"providers":[{"name":"a/e","description":"","type":""}]}`, string(file.Data))
+	file, ok = x.Providers("x/y")
+	assert.True(t, ok)
+	assert.JSONEq(t, `{"providers":[]}`, string(file.Data))
+
+	// the unchanged files keep their Last-Modified
+	metadata, _ := x.Metadata("a/b")
+	time.Sleep(1100 * time.Millisecond)
+	assert.NoError(t, x.Reload())
+	after, _ := x.PackagesJSON()
+	assert.Equal(t, root.LastModified, after.LastModified)
+	afterMetadata, _ := x.Metadata("a/b")
+	assert.Equal(t, metadata, afterMetadata)
+
+	// the metadata-url satis has written is kept
+	ioutil.WriteFile(filepath.Join(repo, "packages.json"), []byte(`{"packages":[],"metadata-url":"/repo/p2/%package%.json"}`), 0644)
+	assert.NoError(t, x.Reload())
+	after, _ = x.PackagesJSON()
+	assert.NotEqual(t, root.ETag, after.ETag)
+	assert.True(t, root.LastModified.Before(after.LastModified))
+	var fields map[string]interface{}
+	json.Unmarshal(after.Data, &fields)
+	assert.Equal(t, "/repo/p2/%package%.json", fields["metadata-url"])
+	assert.Equal(t, "/repo/providers/%package%.json", fields["providers-api"])
+
+	os.Remove(filepath.Join(repo, "packages.json"))
+	assert.NoError(t, x.Reload())
+	_, ok = x.PackagesJSON()
+	assert.False(t, ok)
+	_, ok = x.Metadata("a/b")
+	assert.False(t, ok)
+}
+
+func TestServiceIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "satis-repo")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "satis")
+	script := "#!/bin/sh\nmkdir -p \"$3/p2/a\"\necho '{\"packages\":{\"a/b\":[]}}' > \"$3/p2/a/b.json\"\necho '{\"packages\":[]}' > \"$3/packages.json\"\n"
+	ioutil.WriteFile(path, []byte(script), 0755)
+	repo := filepath.Join(dir, "repo")
+
+	s, ch, stop := startService(t, satis.ServiceParam{SatisPath: path, RepoPath: repo, Timeout: 5 * time.Second})
+	defer stop()
+	_, ok := s.Index().PackagesJSON()
+	assert.False(t, ok)
+
+	_, _, err = s.Rebuild()
+	assert.NoError(t, err)
+	assert.NoError(t, (<-ch).Error)
+	_, ok = s.Index().Metadata("a/b")
+	assert.True(t, ok)
+
+	s, _, stop = startService(t, satis.ServiceParam{SatisPath: path, RepoPath: repo, NoIndex: true})
+	defer stop()
+	assert.Nil(t, s.Index())
+}
@@ -0,0 +1,432 @@
+package satis
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// JobState represents the state of a job.
+type JobState string
+
+// Job states.
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobDiscarded JobState = "discarded"
+	JobTimedOut  JobState = "timedout"
+	JobCancelled JobState = "cancelled"
+)
+
+// Finished determines whether the job has reached its final state.
+func (s JobState) Finished() bool {
+	return s != JobQueued && s != JobRunning
+}
+
+// Job kinds.
+const (
+	JobKindRebuild  = "rebuild"
+	JobKindPartial  = "partial"
+	JobKindRemove   = "remove"
+	JobKindRollback = "rollback"
+	JobKindSwitch   = "switch"
+)
+
+// Job represents a request queued to the service.
+type Job struct {
+	ID         string       `json:"id"`
+	Kind       string       `json:"kind"`
+	Package    *PackageInfo `json:"package,omitempty"`
+	Revision   int          `json:"revision,omitempty"`
+	Generation string       `json:"generation,omitempty"`
+	State      JobState     `json:"state"`
+	QueuedAt   time.Time    `json:"queued_at"`
+	StartedAt  *time.Time   `json:"started_at,omitempty"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+	Attempts   int          `json:"attempts,omitempty"`
+	ExitCode   *int         `json:"exit_code,omitempty"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// Job event types.
+const (
+	JobEventState  = "state"
+	JobEventOutput = "output"
+)
+
+// JobEvent notifies a job state transition or a chunk of satis output.
+type JobEvent struct {
+	Type   string `json:"type"`
+	Job    *Job   `json:"job,omitempty"`
+	Output []byte `json:"output,omitempty"`
+}
+
+// JobSubscription streams the events of a job.
+type JobSubscription struct {
+	// Job is the job state at the time of the subscription.
+	Job Job
+	// Output is the satis output produced before the subscription.
+	Output []byte
+	// Events delivers the events after the subscription. Events are dropped
+	// rather than blocking satis when the receiver falls behind.
+	Events <-chan JobEvent
+	// Close ends the subscription.
+	Close func()
+}
+
+// jobEventBuffer is the number of events buffered for each subscriber.
+const jobEventBuffer = 256
+
+// jobStore keeps jobs and their satis output in memory.
+type jobStore struct {
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	outputs map[string]*jobOutput
+	ids     []string // in queued order
+
+	// subscribers receive state events keyed by job ID; "" for all jobs.
+	subscribers map[string][]chan JobEvent
+
+	// maxCount is the number of finished jobs to be kept. Zero means no limit.
+	maxCount int
+	// maxAge is how long finished jobs are kept. Zero means no limit.
+	maxAge time.Duration
+}
+
+func newJobStore(maxCount int, maxAge time.Duration) *jobStore {
+	return &jobStore{
+		jobs:        make(map[string]*Job),
+		outputs:     make(map[string]*jobOutput),
+		subscribers: make(map[string][]chan JobEvent),
+		maxCount:    maxCount,
+		maxAge:      maxAge,
+	}
+}
+
+// add registers a new queued job.
+func (s *jobStore) add(kind string, pkg *PackageInfo) Job {
+	return s.addJob(Job{Kind: kind, Package: pkg})
+}
+
+// addJob registers a new queued job of the kind and the parameters in job.
+func (s *jobStore) addJob(job Job) Job {
+	job.ID = newJobID()
+	job.State = JobQueued
+	job.QueuedAt = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = &job
+	s.outputs[job.ID] = new(jobOutput)
+	s.ids = append(s.ids, job.ID)
+	s.publish(&job)
+	s.prune()
+	return job
+}
+
+// restore registers the job replayed from the queue journal.
+func (s *jobStore) restore(job Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[job.ID]; ok {
+		return
+	}
+	s.jobs[job.ID] = &job
+	s.outputs[job.ID] = new(jobOutput)
+	s.ids = append(s.ids, job.ID)
+}
+
+// subscribe starts streaming the events of the job. id "" subscribes
+// state events of all jobs.
+func (s *jobStore) subscribe(id string) (JobSubscription, bool) {
+	ch := make(chan JobEvent, jobEventBuffer)
+
+	s.mu.Lock()
+	var sub JobSubscription
+	var output *jobOutput
+	if id != "" {
+		job, ok := s.jobs[id]
+		if !ok {
+			s.mu.Unlock()
+			return sub, false
+		}
+		sub.Job = *job
+		output = s.outputs[id]
+	}
+	s.subscribers[id] = append(s.subscribers[id], ch)
+	s.mu.Unlock()
+
+	if output != nil {
+		// state events are already on ch, so that nothing slips between them
+		sub.Output = output.subscribe(ch)
+	}
+
+	var once sync.Once
+	sub.Events = ch
+	sub.Close = func() {
+		once.Do(func() {
+			if output != nil {
+				output.unsubscribe(ch)
+			}
+			s.mu.Lock()
+			subs := s.subscribers[id]
+			for i, c := range subs {
+				if c == ch {
+					s.subscribers[id] = append(subs[:i], subs[i+1:]...)
+					break
+				}
+			}
+			if len(s.subscribers[id]) == 0 {
+				delete(s.subscribers, id)
+			}
+			s.mu.Unlock()
+		})
+	}
+	return sub, true
+}
+
+// publish sends the job state to the subscribers without blocking.
+// The caller must hold the lock.
+func (s *jobStore) publish(job *Job) {
+	for _, key := range []string{job.ID, ""} {
+		for _, ch := range s.subscribers[key] {
+			copied := *job
+			sendLatest(ch, JobEvent{Type: JobEventState, Job: &copied})
+		}
+	}
+}
+
+// sendLatest sends the event without blocking. A slow subscriber loses the
+// oldest buffered event instead, so that it never misses the latest state,
+// which ends the stream once the job finishes.
+func sendLatest(ch chan JobEvent, ev JobEvent) {
+	select {
+	case ch <- ev:
+		return
+	default:
+	}
+	select {
+	case <-ch:
+	default:
+	}
+	select {
+	case ch <- ev:
+	default:
+	}
+}
+
+// output returns the writer which keeps the satis output of the job.
+func (s *jobStore) output(id string) *jobOutput {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o, ok := s.outputs[id]; ok {
+		return o
+	}
+	// the job has been pruned already; the output goes nowhere
+	return new(jobOutput)
+}
+
+// log returns the satis output of the job.
+func (s *jobStore) log(id string) ([]byte, bool) {
+	s.mu.Lock()
+	o, ok := s.outputs[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	return o.Bytes(), true
+}
+
+// get returns a copy of the job.
+func (s *jobStore) get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// list returns copies of all jobs, the newest first.
+func (s *jobStore) list() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	jobs := make([]Job, 0, len(s.ids))
+	for i := len(s.ids) - 1; 0 <= i; i-- {
+		jobs = append(jobs, *s.jobs[s.ids[i]])
+	}
+	return jobs
+}
+
+// pending determines whether a job of the kind is queued or running.
+// For partial jobs, name specifies the package.
+func (s *jobStore) pending(kind, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs {
+		if job.Kind != kind || job.State.Finished() {
+			continue
+		}
+		if kind == JobKindRebuild || (job.Package != nil && job.Package.Name == name) {
+			return true
+		}
+	}
+	return false
+}
+
+// start marks the job as running. It returns false if the job has been
+// cancelled or pruned.
+func (s *jobStore) start(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok || job.State != JobQueued {
+		return false
+	}
+
+	now := time.Now()
+	job.State = JobRunning
+	job.StartedAt = &now
+	s.publish(job)
+	return true
+}
+
+// attempt counts a satis build attempt of the running job.
+func (s *jobStore) attempt(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[id]; ok && job.State == JobRunning {
+		job.Attempts++
+		s.publish(job)
+	}
+}
+
+// cancelQueued marks the queued job as cancelled.
+func (s *jobStore) cancelQueued(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if job.State != JobQueued {
+		return ErrJobFinished
+	}
+
+	now := time.Now()
+	job.State = JobCancelled
+	job.FinishedAt = &now
+	job.Error = ErrJobCancelled.Error()
+	s.publish(job)
+	return nil
+}
+
+// cancelled determines whether the job has been cancelled or pruned.
+func (s *jobStore) cancelled(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	return !ok || job.State == JobCancelled
+}
+
+// finish marks the job as finished with the result of the satis execution.
+func (s *jobStore) finish(id string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	job.FinishedAt = &now
+	job.ExitCode = exitCode(err)
+	switch {
+	case err == nil:
+		job.State = JobSucceeded
+	case errors.Cause(err) == ErrJobCancelled:
+		job.State = JobCancelled
+		job.Error = err.Error()
+	case isTimeout(err):
+		job.State = JobTimedOut
+		job.Error = err.Error()
+	default:
+		job.State = JobFailed
+		job.Error = err.Error()
+	}
+	s.publish(job)
+	s.prune()
+}
+
+// discard marks the queued job as discarded.
+func (s *jobStore) discard(id string, reason error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[id]; ok && job.State == JobQueued {
+		now := time.Now()
+		job.State = JobDiscarded
+		job.FinishedAt = &now
+		job.Error = reason.Error()
+		s.publish(job)
+	}
+	s.prune()
+}
+
+// prune drops the oldest finished jobs beyond maxCount or older than maxAge.
+// The caller must hold the lock.
+func (s *jobStore) prune() {
+	finished := 0
+	for _, id := range s.ids {
+		if s.jobs[id].State.Finished() {
+			finished++
+		}
+	}
+
+	now := time.Now()
+	ids := s.ids[:0]
+	for _, id := range s.ids {
+		job := s.jobs[id]
+		if job.State.Finished() {
+			expired := 0 < s.maxAge && job.FinishedAt != nil && s.maxAge < now.Sub(*job.FinishedAt)
+			if expired || (0 < s.maxCount && s.maxCount < finished) {
+				delete(s.jobs, id)
+				delete(s.outputs, id)
+				finished--
+				continue
+			}
+		}
+		ids = append(ids, id)
+	}
+	s.ids = ids
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// exitCode extracts the satis process exit code from the error.
+// It returns nil when the process did not exit by itself.
+func exitCode(err error) *int {
+	code := 0
+	if err == nil {
+		return &code
+	}
+	if exitErr, ok := errors.Cause(err).(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Exited() {
+			code = status.ExitStatus()
+			return &code
+		}
+	}
+	return nil
+}
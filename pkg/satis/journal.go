@@ -0,0 +1,206 @@
+package satis
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// journalRecord is a line of the queue journal.
+type journalRecord struct {
+	Op  string `json:"op"` // "queue" or "done"
+	Job *Job   `json:"job,omitempty"`
+	ID  string `json:"id,omitempty"`
+}
+
+// journalCompactRecords is the number of records beyond which the journal
+// gets compacted when a job is done, unless most of them are pending.
+var journalCompactRecords = 1000
+
+// queueJournal persists the queued jobs into an append-only JSON lines file
+// so that they survive restarts.
+type queueJournal struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	// records is the number of records in the file, and pending are the
+	// jobs not done yet, in queued order.
+	records int
+	pending []Job
+}
+
+// openJournal reads the journal file at path and returns the jobs not done yet,
+// in queued order. The file gets compacted to contain only those jobs.
+func openJournal(path string) (*queueJournal, []Job, error) {
+	pending, err := readJournal(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	j := &queueJournal{path: path}
+	if err = j.compact(pending); err != nil {
+		return nil, nil, err
+	}
+	return j, pending, nil
+}
+
+func readJournal(path string) ([]Job, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Errorf("failed to open queue journal: %s", err)
+	}
+	defer f.Close()
+
+	var ids []string
+	jobs := make(map[string]Job)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var rec journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// a line torn by a crash; the rest is still usable
+			continue
+		}
+		switch {
+		case rec.Op == "queue" && rec.Job != nil:
+			ids = append(ids, rec.Job.ID)
+			jobs[rec.Job.ID] = *rec.Job
+		case rec.Op == "done":
+			delete(jobs, rec.ID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Errorf("failed to read queue journal: %s", err)
+	}
+
+	var pending []Job
+	for _, id := range ids {
+		if job, ok := jobs[id]; ok {
+			job.State = JobQueued
+			job.StartedAt = nil
+			pending = append(pending, job)
+			delete(jobs, id)
+		}
+	}
+	return pending, nil
+}
+
+// compact rewrites the journal with the pending jobs and reopens it.
+func (j *queueJournal) compact(pending []Job) error {
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(j.path), filepath.Base(j.path)+".tmp")
+	if err != nil {
+		return errors.Errorf("failed to create queue journal: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for i := range pending {
+		data, _ := json.Marshal(journalRecord{Op: "queue", Job: &pending[i]})
+		w.Write(append(data, '\n'))
+	}
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), j.path)
+	}
+	if err != nil {
+		return errors.Errorf("failed to write queue journal: %s", err)
+	}
+
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Errorf("failed to open queue journal: %s", err)
+	}
+	j.file = f
+	j.records = len(pending)
+	j.pending = append([]Job(nil), pending...)
+	return nil
+}
+
+// queued records the newly queued job.
+func (j *queueJournal) queued(job Job) error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.append(journalRecord{Op: "queue", Job: &job}); err != nil {
+		return err
+	}
+	j.pending = append(j.pending, job)
+	return nil
+}
+
+// done records that the job has finished. The journal gets compacted when
+// the records of the finished jobs have piled up.
+func (j *queueJournal) done(id string) error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.append(journalRecord{Op: "done", ID: id}); err != nil {
+		return err
+	}
+	for i := range j.pending {
+		if j.pending[i].ID == id {
+			j.pending = append(j.pending[:i], j.pending[i+1:]...)
+			break
+		}
+	}
+	if j.records <= journalCompactRecords || j.records < 2*len(j.pending) {
+		return nil
+	}
+	return j.compact(j.pending)
+}
+
+// append writes the record to the file. j.mu must be held.
+func (j *queueJournal) append(rec journalRecord) error {
+	if j.file == nil {
+		return errors.Errorf("failed to write queue journal: closed")
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err = j.file.Write(append(data, '\n')); err != nil {
+		return errors.Errorf("failed to write queue journal: %s", err)
+	}
+	j.records++
+	return j.file.Sync()
+}
+
+// Close closes the journal file.
+func (j *queueJournal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
@@ -0,0 +1,431 @@
+package satis
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// jsonValue is a JSON value located in the source text.
+type jsonValue struct {
+	// start and end are the span of the value in the source.
+	start, end int
+	// kind is '{' for an object, '[' for an array and 0 for the others.
+	kind byte
+	// keys are the member keys of an object, and keyStarts are their offsets.
+	keys      []string
+	keyStarts []int
+	// values are the member values of an object or the elements of an array.
+	values []*jsonValue
+}
+
+// member returns the value of the object member, or nil.
+func (v *jsonValue) member(key string) *jsonValue {
+	for i, k := range v.keys {
+		if k == key {
+			return v.values[i]
+		}
+	}
+	return nil
+}
+
+// jsonParser parses JSON text keeping the locations of the values.
+// It expects the text already validated by a JSON decoder.
+type jsonParser struct {
+	src []byte
+	pos int
+}
+
+func parseJSONValue(src []byte) (*jsonValue, error) {
+	p := &jsonParser{src: src}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (p *jsonParser) skipSpace() {
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case ' ', '\t', '\r', '\n':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *jsonParser) errorf(format string, args ...interface{}) error {
+	return errors.Errorf("offset %d: "+format, append([]interface{}{p.pos}, args...)...)
+}
+
+func (p *jsonParser) value() (*jsonValue, error) {
+	p.skipSpace()
+	if len(p.src) <= p.pos {
+		return nil, p.errorf("unexpected end of JSON")
+	}
+
+	v := &jsonValue{start: p.pos}
+	switch c := p.src[p.pos]; c {
+	case '{', '[':
+		v.kind = c
+		closing := byte('}')
+		if c == '[' {
+			closing = ']'
+		}
+		p.pos++
+		p.skipSpace()
+		if p.pos < len(p.src) && p.src[p.pos] == closing {
+			p.pos++
+			break
+		}
+		for {
+			if c == '{' {
+				p.skipSpace()
+				keyStart := p.pos
+				key, err := p.str()
+				if err != nil {
+					return nil, err
+				}
+				v.keyStarts = append(v.keyStarts, keyStart)
+				p.skipSpace()
+				if len(p.src) <= p.pos || p.src[p.pos] != ':' {
+					return nil, p.errorf("':' expected")
+				}
+				p.pos++
+				v.keys = append(v.keys, key)
+			}
+			elem, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			v.values = append(v.values, elem)
+
+			p.skipSpace()
+			if len(p.src) <= p.pos {
+				return nil, p.errorf("unexpected end of JSON")
+			}
+			if p.src[p.pos] == closing {
+				p.pos++
+				break
+			}
+			if p.src[p.pos] != ',' {
+				return nil, p.errorf("',' expected")
+			}
+			p.pos++
+		}
+	case '"':
+		if _, err := p.str(); err != nil {
+			return nil, err
+		}
+	default:
+		for p.pos < len(p.src) && bytes.IndexByte([]byte(",]} \t\r\n"), p.src[p.pos]) < 0 {
+			p.pos++
+		}
+		if p.pos == v.start {
+			return nil, p.errorf("value expected")
+		}
+	}
+	v.end = p.pos
+	return v, nil
+}
+
+func (p *jsonParser) str() (string, error) {
+	if len(p.src) <= p.pos || p.src[p.pos] != '"' {
+		return "", p.errorf("string expected")
+	}
+	start := p.pos
+	for p.pos++; p.pos < len(p.src); p.pos++ {
+		switch p.src[p.pos] {
+		case '\\':
+			p.pos++
+		case '"':
+			p.pos++
+			// decoded as JSON, which has escapes Go strings lack, such as
+			// "\/" written by PHP
+			var s string
+			if err := json.Unmarshal(p.src[start:p.pos], &s); err != nil {
+				return "", p.errorf("invalid string")
+			}
+			return s, nil
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// jsonEditor edits JSON text in place. Only the edited parts change, and
+// the rest of the text, such as the key order and the indentation, stays.
+type jsonEditor struct {
+	src []byte
+	// indent is the indentation unit of the text.
+	indent string
+	// colon is the separator between a key and its value.
+	colon string
+	// compact is set for a single line text.
+	compact bool
+}
+
+func newJSONEditor(src []byte) (*jsonEditor, error) {
+	e := &jsonEditor{src: src, indent: "  ", colon: ": "}
+	root, err := e.root()
+	if err != nil {
+		return nil, err
+	}
+
+	// learn the style from the first indented line and the first member
+	for _, line := range bytes.Split(src, []byte("\n"))[1:] {
+		if ws := leadingSpace(line); 0 < len(ws) && len(ws) < len(line) {
+			e.indent = string(ws)
+			break
+		}
+	}
+	e.compact = bytes.IndexByte(src, '\n') < 0 && 0 < len(root.values)
+	if obj := firstObject(root); obj != nil {
+		keyEnd := obj.values[0].start
+		for keyEnd--; e.src[keyEnd] != ':'; keyEnd-- {
+		}
+		if e.src[keyEnd+1] != ' ' {
+			e.colon = ":"
+		}
+	}
+	return e, nil
+}
+
+// firstObject returns the first object having members, in the source order.
+func firstObject(v *jsonValue) *jsonValue {
+	if v.kind == '{' && 0 < len(v.keys) {
+		return v
+	}
+	for _, elem := range v.values {
+		if obj := firstObject(elem); obj != nil {
+			return obj
+		}
+	}
+	return nil
+}
+
+func leadingSpace(line []byte) []byte {
+	i := 0
+	for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+		i++
+	}
+	return line[:i]
+}
+
+// Bytes returns the edited JSON text.
+func (e *jsonEditor) Bytes() []byte {
+	return e.src
+}
+
+func (e *jsonEditor) root() (*jsonValue, error) {
+	return parseJSONValue(e.src)
+}
+
+// lookup returns the value at the path of object keys (string) and array
+// indexes (int), or nil if there is none.
+func (e *jsonEditor) lookup(path ...interface{}) (*jsonValue, error) {
+	v, err := e.root()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range path {
+		if v == nil {
+			return nil, nil
+		}
+		switch key := key.(type) {
+		case string:
+			if v.kind != '{' {
+				return nil, nil
+			}
+			v = v.member(key)
+		case int:
+			if v.kind != '[' || key < 0 || len(v.values) <= key {
+				return nil, nil
+			}
+			v = v.values[key]
+		}
+	}
+	return v, nil
+}
+
+func (e *jsonEditor) container(kind byte, path []interface{}) (*jsonValue, error) {
+	v, err := e.lookup(path...)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil || v.kind != kind {
+		return nil, errors.Errorf("no container at %v", path)
+	}
+	return v, nil
+}
+
+// set sets the member of the object at path, replacing the existing value
+// or appending a new member.
+func (e *jsonEditor) set(path []interface{}, key string, value interface{}) error {
+	obj, err := e.container('{', path)
+	if err != nil {
+		return err
+	}
+
+	if old := obj.member(key); old != nil {
+		inline := bytes.IndexByte(e.src[old.start:old.end], '\n') < 0
+		data, err := e.marshal(value, e.lineIndent(old.start), inline)
+		if err != nil {
+			return err
+		}
+		e.splice(old.start, old.end, data)
+		return nil
+	}
+
+	name, _ := json.Marshal(key)
+	return e.insert(obj, func(indent string, inline bool) ([]byte, error) {
+		data, err := e.marshal(value, indent, inline)
+		if err != nil {
+			return nil, err
+		}
+		return append(append(name, e.colon...), data...), nil
+	})
+}
+
+// append appends the element to the array at path.
+func (e *jsonEditor) append(path []interface{}, value interface{}) error {
+	arr, err := e.container('[', path)
+	if err != nil {
+		return err
+	}
+	return e.insert(arr, func(indent string, inline bool) ([]byte, error) {
+		return e.marshal(value, indent, inline)
+	})
+}
+
+// remove removes the member (string key) or the element (int index) of
+// the container at path. It does nothing if there is no such entry.
+func (e *jsonEditor) remove(path []interface{}, key interface{}) error {
+	c, err := e.lookup(path...)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		return nil
+	}
+
+	i := -1
+	switch key := key.(type) {
+	case string:
+		if c.kind == '{' {
+			for j, k := range c.keys {
+				if k == key {
+					i = j
+				}
+			}
+		}
+	case int:
+		if c.kind == '[' && 0 <= key && key < len(c.values) {
+			i = key
+		}
+	}
+	if i < 0 {
+		return nil
+	}
+
+	entryStart := func(i int) int {
+		if c.kind == '{' {
+			return c.keyStarts[i]
+		}
+		return c.values[i].start
+	}
+	switch {
+	case len(c.values) == 1:
+		e.splice(c.start+1, c.end-1, nil)
+	case 0 < i:
+		// with the separator after the previous entry
+		e.splice(c.values[i-1].end, c.values[i].end, nil)
+	default:
+		e.splice(entryStart(0), entryStart(1), nil)
+	}
+	return nil
+}
+
+// insert adds an entry built by fn at the end of the container, following
+// the layout of the existing entries.
+func (e *jsonEditor) insert(c *jsonValue, fn func(indent string, inline bool) ([]byte, error)) error {
+	if len(c.values) == 0 {
+		if e.compact {
+			// a single line text stays so
+			entry, err := fn("", true)
+			if err != nil {
+				return err
+			}
+			e.splice(c.start+1, c.end-1, entry)
+			return nil
+		}
+
+		outer := e.lineIndent(c.start)
+		indent := outer + e.indent
+		entry, err := fn(indent, false)
+		if err != nil {
+			return err
+		}
+		data := append([]byte("\n"+indent), entry...)
+		data = append(data, "\n"+outer...)
+		e.splice(c.start+1, c.end-1, data)
+		return nil
+	}
+
+	// the first entry starts at its key in an object
+	first := c.values[0].start
+	if c.kind == '{' {
+		first = c.keyStarts[0]
+	}
+
+	var sep, indent string
+	inline := bytes.IndexByte(e.src[c.start:first], '\n') < 0
+	if inline {
+		sep = ","
+		if e.colon == ": " {
+			sep = ", "
+		}
+	} else {
+		indent = e.lineIndent(first)
+		sep = ",\n" + indent
+	}
+
+	entry, err := fn(indent, inline)
+	if err != nil {
+		return err
+	}
+	last := c.values[len(c.values)-1]
+	e.splice(last.end, last.end, append([]byte(sep), entry...))
+	return nil
+}
+
+// lineIndent returns the leading white spaces of the line at the offset.
+func (e *jsonEditor) lineIndent(offset int) string {
+	start := bytes.LastIndexByte(e.src[:offset], '\n') + 1
+	return string(leadingSpace(e.src[start:offset]))
+}
+
+// marshal encodes the value laid out at the indentation, or on a single line.
+// "<", ">" and "&" are kept as they are, as satis and composer write them.
+func (e *jsonEditor) marshal(value interface{}, indent string, inline bool) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if !inline {
+		enc.SetIndent(indent, e.indent)
+	}
+	if err := enc.Encode(value); err != nil {
+		return nil, errors.Errorf("failed to encode JSON: %s", err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func (e *jsonEditor) splice(start, end int, data []byte) {
+	src := make([]byte, 0, len(e.src)-(end-start)+len(data))
+	src = append(src, e.src[:start]...)
+	src = append(src, data...)
+	src = append(src, e.src[end:]...)
+	e.src = src
+}
@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package satis
+
+import "os"
+
+// lockFile does nothing on the platforms without flock.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// unlockFile does nothing on the platforms without flock.
+func unlockFile(f *os.File) error {
+	return nil
+}
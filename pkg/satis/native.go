@@ -0,0 +1,425 @@
+package satis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Builders of the Composer repository metadata.
+const (
+	// BuilderSatis runs the satis command.
+	BuilderSatis = "satis"
+	// BuilderNative makes the Composer 2 metadata by itself. It reads the
+	// "vcs" and "git" repositories with git, and the "package" entries.
+	// With the "archive" config, it makes the dist archives of the git
+	// versions as well.
+	BuilderNative = "native"
+)
+
+// nativeBuilder makes packages.json and the "p2" files of a Composer 2
+// repository from the satis config without satis. The git repositories are
+// mirrored in mirrorPath and fetched on each build.
+type nativeBuilder struct {
+	gitPath    string
+	mirrorPath string
+	// mu serializes the updates of packages.json by the partial builds.
+	mu sync.Mutex
+}
+
+// composerIndex is packages.json of a Composer 2 repository.
+type composerIndex struct {
+	Packages          []interface{} `json:"packages"`
+	MetadataURL       string        `json:"metadata-url"`
+	AvailablePackages []string      `json:"available-packages"`
+}
+
+// composerVersion is a version of a package in the metadata, which is the
+// composer.json of the version with "version", "source" and the like.
+type composerVersion map[string]interface{}
+
+// rootOnlyFields are the composer.json fields which only a root package uses.
+var rootOnlyFields = []string{"config", "minimum-stability", "prefer-stable", "repositories", "version"}
+
+// composerTimeFormat is the format of "time" in the metadata.
+const composerTimeFormat = "2006-01-02T15:04:05+00:00"
+
+func newNativeBuilder(gitPath, mirrorPath string) *nativeBuilder {
+	if gitPath == "" {
+		gitPath = "git"
+	}
+	return &nativeBuilder{gitPath: gitPath, mirrorPath: mirrorPath}
+}
+
+// build writes the metadata of the packages of names, or of all packages
+// when names is empty, into outputDir.
+func (b *nativeBuilder) build(ctx context.Context, configPath, outputDir string, names []string, out io.Writer) error {
+	config, err := ReadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	repos, err := configReadRepos(config)
+	if err != nil {
+		return err
+	}
+	requires, err := configReadRequires(config)
+	if err != nil {
+		return err
+	}
+	requireAll, _ := config["require-all"].(bool)
+	sel := packageSelector{all: requireAll || len(requires) == 0, requires: requires, targets: names}
+	arch, err := newArchiver(config, outputDir)
+	if err != nil {
+		return err
+	}
+
+	packages := make(map[string][]composerVersion)
+	for i, repo := range repos {
+		kind, _ := repo["type"].(string)
+		switch {
+		case kind == "vcs" || kind == "git":
+			repoURL, _ := repo["url"].(string)
+			err = b.readGitRepository(ctx, repoURL, sel, arch, packages, out)
+		case kind == "package":
+			err = readInlinePackages(repo["package"], sel, packages, out)
+		case kind == "" && disabledRepository(repo):
+			continue
+		default:
+			err = errors.Errorf("repositories/%d: native builder does not support %q repositories", i, kind)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	var missing []string
+	for _, name := range names {
+		if _, ok := packages[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if 0 < len(missing) {
+		return errors.Errorf("package not found in the repositories: %s", strings.Join(missing, ", "))
+	}
+	if err = b.write(config, outputDir, names, packages, out); err != nil {
+		return err
+	}
+	if arch != nil {
+		// a partial build leaves the archives of the other packages
+		return arch.prune(names)
+	}
+	return nil
+}
+
+// packageSelector chooses the packages to be built as satis does: those in
+// "require", or all of them for "require-all" or an empty "require". The
+// version constraints are not applied; every version is built.
+type packageSelector struct {
+	all      bool
+	requires map[string]interface{}
+	// targets limits the packages in a partial build.
+	targets []string
+}
+
+func (p packageSelector) wanted(name string) bool {
+	if 0 < len(p.targets) && !contains(p.targets, name) {
+		return false
+	}
+	if p.all {
+		return true
+	}
+	for key := range p.requires {
+		if strings.ToLower(key) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// disabledRepository determines whether the entry disables a default
+// repository, such as {"packagist.org": false}.
+func disabledRepository(repo map[string]interface{}) bool {
+	for _, value := range repo {
+		return len(repo) == 1 && value == false
+	}
+	return false
+}
+
+// readGitRepository adds the versions of the package in the git repository,
+// its branches and tags, to packages. The versions get their dist archives
+// when arch is given.
+func (b *nativeBuilder) readGitRepository(ctx context.Context, repoURL string, sel packageSelector, arch *archiver, packages map[string][]composerVersion, out io.Writer) error {
+	m := newGitMirror(b.gitPath, b.mirrorPath, repoURL)
+	// a partial build does not fetch the repositories of the other packages
+	if 0 < len(sel.targets) && m.exists() {
+		if files, err := m.files(ctx, []string{"HEAD"}, "composer.json"); err == nil {
+			if name := composerName(files[0]); name != "" && !sel.wanted(name) {
+				return nil
+			}
+		}
+	}
+
+	fmt.Fprintf(out, "Reading %s\n", repoURL)
+	if err := m.update(ctx, repoURL, out); err != nil {
+		return err
+	}
+	head, err := m.head(ctx)
+	if err != nil {
+		return err
+	}
+	refs, err := m.refs(ctx)
+	if err != nil {
+		return err
+	}
+	commits := make([]string, len(refs))
+	for i, ref := range refs {
+		commits[i] = ref.Commit
+	}
+	files, err := m.files(ctx, commits, "composer.json")
+	if err != nil {
+		return err
+	}
+
+	// the package is named by the default branch, as Composer does
+	var name string
+	for i, ref := range refs {
+		if !ref.Tag && ref.Name == head {
+			name = composerName(files[i])
+		}
+	}
+	if name == "" {
+		return errors.Errorf("%s: no package name in composer.json of branch %q", repoURL, head)
+	}
+	// the name becomes the paths of the metadata and the archives
+	if !ValidPackageName(name) {
+		fmt.Fprintf(out, "Skipped %s: invalid package name %q\n", repoURL, name)
+		return nil
+	}
+	if !sel.wanted(name) {
+		return nil
+	}
+
+	for i, ref := range refs {
+		var composer map[string]interface{}
+		if files[i] == nil || json.Unmarshal(files[i], &composer) != nil {
+			continue
+		}
+		if n, _ := composer["name"].(string); n != "" && strings.ToLower(n) != name {
+			continue
+		}
+
+		version, normalized := branchVersion(ref.Name)
+		if ref.Tag {
+			var ok bool
+			if normalized, ok = normalizeVersion(ref.Name); !ok || isDevVersion(normalized) {
+				continue
+			}
+			version = ref.Name
+		}
+		v := newComposerVersion(composer, name, version, normalized)
+		v["source"] = map[string]string{"type": "git", "url": repoURL, "reference": ref.Commit}
+		if !ref.Time.IsZero() {
+			v["time"] = ref.Time.Format(composerTimeFormat)
+		}
+		if !ref.Tag && ref.Name == head {
+			v["default-branch"] = true
+		}
+		if arch != nil && arch.wanted(name, normalized) {
+			dist, err := arch.archive(ctx, m, name, version, ref.Commit, out)
+			if err != nil {
+				return err
+			}
+			v["dist"] = dist
+		}
+		addComposerVersion(packages, v)
+	}
+	return nil
+}
+
+// readInlinePackages adds the packages defined in a "package" repository,
+// an object or an array of them, to packages.
+func readInlinePackages(value interface{}, sel packageSelector, packages map[string][]composerVersion, out io.Writer) error {
+	var entries []interface{}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		entries = []interface{}{v}
+	case []interface{}:
+		entries = v
+	}
+
+	for _, entry := range entries {
+		pkg, _ := entry.(map[string]interface{})
+		name, _ := pkg["name"].(string)
+		version, _ := pkg["version"].(string)
+		name = strings.ToLower(name)
+		if name == "" {
+			continue
+		}
+		if !ValidPackageName(name) {
+			fmt.Fprintf(out, "Skipped package %q: invalid package name\n", name)
+			continue
+		}
+		if !sel.wanted(name) {
+			continue
+		}
+		normalized, ok := normalizeVersion(version)
+		if !ok {
+			return errors.Errorf("package %s: invalid version %q", name, version)
+		}
+		v := newComposerVersion(pkg, name, version, normalized)
+		addComposerVersion(packages, v)
+	}
+	return nil
+}
+
+// newComposerVersion makes a version of the package from its composer.json.
+func newComposerVersion(composer map[string]interface{}, name, version, normalized string) composerVersion {
+	v := make(composerVersion, len(composer)+2)
+	for key, value := range composer {
+		if !contains(rootOnlyFields, key) {
+			v[key] = value
+		}
+	}
+	v["name"] = name
+	v["version"] = version
+	v["version_normalized"] = normalized
+	return v
+}
+
+// addComposerVersion adds the version unless the package has it already.
+func addComposerVersion(packages map[string][]composerVersion, v composerVersion) {
+	name := v["name"].(string)
+	for _, other := range packages[name] {
+		if other["version_normalized"] == v["version_normalized"] {
+			return
+		}
+	}
+	packages[name] = append(packages[name], v)
+}
+
+// composerName returns the lowercased package name in composer.json.
+func composerName(data []byte) string {
+	var composer struct {
+		Name string `json:"name"`
+	}
+	if json.Unmarshal(data, &composer) != nil {
+		return ""
+	}
+	return strings.ToLower(composer.Name)
+}
+
+// write writes "p2/<name>.json" of the tagged versions and
+// "p2/<name>~dev.json" of the branches for each package, and packages.json.
+// A full build removes the files of the packages it did not find, while a
+// partial build adds the packages to the existing packages.json.
+func (b *nativeBuilder) write(config map[string]interface{}, outputDir string, targets []string, packages map[string][]composerVersion, out io.Writer) error {
+	names := make([]string, 0, len(packages))
+	for name := range packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	written := make(map[string]bool)
+	for _, name := range names {
+		var tagged, dev []composerVersion
+		for _, v := range packages[name] {
+			if isDevVersion(v["version_normalized"].(string)) {
+				dev = append(dev, v)
+			} else {
+				tagged = append(tagged, v)
+			}
+		}
+		for suffix, versions := range map[string][]composerVersion{"": tagged, "~dev": dev} {
+			path := filepath.Join(outputDir, "p2", filepath.FromSlash(name)+suffix+".json")
+			if err := writeMetadata(path, name, versions); err != nil {
+				return err
+			}
+			written[path] = true
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	indexPath := filepath.Join(outputDir, "packages.json")
+	index := composerIndex{Packages: []interface{}{}, MetadataURL: repositoryPrefix(config) + "/p2/%package%.json"}
+	if 0 < len(targets) {
+		var prev composerIndex
+		if data, err := ioutil.ReadFile(indexPath); err == nil && json.Unmarshal(data, &prev) == nil {
+			for _, name := range prev.AvailablePackages {
+				if !contains(names, name) {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+		}
+	} else if err := pruneMetadata(outputDir, written); err != nil {
+		return err
+	}
+	index.AvailablePackages = names
+
+	data, err := json.MarshalIndent(index, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err = writeFileAtomic(indexPath, append(data, '\n'), 0644); err != nil {
+		return errors.Errorf("failed to write packages.json: %s", err)
+	}
+	fmt.Fprintf(out, "Wrote packages.json with %d packages\n", len(names))
+	return nil
+}
+
+// writeMetadata writes the versions of the package into the "p2" file.
+func writeMetadata(path, name string, versions []composerVersion) error {
+	if versions == nil {
+		versions = []composerVersion{}
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"packages": map[string][]composerVersion{name: versions},
+	})
+	if err != nil {
+		return errors.Errorf("failed to encode metadata of %s: %s", name, err)
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Errorf("failed to write metadata of %s: %s", name, err)
+	}
+	if err = writeFileAtomic(path, data, 0644); err != nil {
+		return errors.Errorf("failed to write metadata of %s: %s", name, err)
+	}
+	return nil
+}
+
+// pruneMetadata removes the "p2" files which the build has not written.
+func pruneMetadata(outputDir string, written map[string]bool) error {
+	root := filepath.Join(outputDir, "p2")
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || written[path] || filepath.Ext(path) != ".json" {
+			return err
+		}
+		return os.Remove(path)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Errorf("failed to remove old metadata: %s", err)
+	}
+	return nil
+}
+
+// repositoryPrefix returns the URL path of "homepage" where the repository
+// is served, such as "/composer", or "" for the root.
+func repositoryPrefix(config map[string]interface{}) string {
+	if homepage, ok := config["homepage"].(string); ok {
+		if u, err := url.Parse(homepage); err == nil {
+			return strings.TrimSuffix(u.Path, "/")
+		}
+	}
+	return ""
+}
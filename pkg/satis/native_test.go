@@ -0,0 +1,318 @@
+package satis_test
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/reedom/satishub/pkg/satis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeVersion(t *testing.T) {
+	for version, expected := range map[string]string{
+		"1.0.0":      "1.0.0.0",
+		"v1.2":       "1.2.0.0",
+		"1.2.3.4":    "1.2.3.4",
+		"v2.0-b1":    "2.0.0.0-beta1",
+		"1.0.0-RC.2": "1.0.0.0-RC2",
+		"1.0-alpha":  "1.0.0.0-alpha",
+		"1.0.0-pl3":  "1.0.0.0-patch3",
+		"1.0-stable": "1.0.0.0",
+		"1.0.0+b123": "1.0.0.0",
+		"2018-03-13": "2018.03.13",
+		"1.x-dev":    "1.9999999.9999999.9999999-dev",
+		"dev-master": "dev-master",
+	} {
+		normalized, ok := satis.NormalizeVersion(version)
+		assert.True(t, ok, version)
+		assert.Equal(t, expected, normalized, version)
+	}
+	for _, version := range []string{"latest", "1.0.0-foo", ""} {
+		_, ok := satis.NormalizeVersion(version)
+		assert.False(t, ok, version)
+	}
+
+	for name, expected := range map[string][2]string{
+		"master":  {"dev-master", "dev-master"},
+		"1.x":     {"1.x-dev", "1.9999999.9999999.9999999-dev"},
+		"v2.1.*":  {"2.1.x-dev", "2.1.9999999.9999999-dev"},
+		"feature": {"dev-feature", "dev-feature"},
+	} {
+		version, normalized := satis.BranchVersion(name)
+		assert.Equal(t, expected, [2]string{version, normalized}, name)
+	}
+}
+
+// gitRepository makes a git repository of package "test/a" with branch
+// "master", "1.x", tag "v1.0.0" and "1.1.0-beta1".
+func gitRepository(t *testing.T, dir string) {
+	steps := [][]string{
+		{"init", "-q"},
+		{"symbolic-ref", "HEAD", "refs/heads/master"},
+		{"add", "composer.json"},
+		{"commit", "-q", "-m", "first"},
+		{"tag", "-a", "-m", "release", "v1.0.0"},
+		{"branch", "1.x"},
+		{"tag", "1.1.0-beta1"},
+		{"tag", "latest"},
+	}
+	composer := `{"name": "Test/A", "description": "test", "require": {"php": ">=7.0"}, "config": {"sort-packages": true}}`
+	if !assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "composer.json"), []byte(composer), 0644)) {
+		t.FailNow()
+	}
+	for _, args := range steps {
+		runGit(t, dir, args...)
+	}
+}
+
+func runGit(t *testing.T, dir string, args ...string) {
+	command := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	command.Dir = dir
+	if out, err := command.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %s: %s", args, err, out)
+	}
+}
+
+func TestNativeBuilder(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir("", "satis-native")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "a")
+	os.Mkdir(source, 0755)
+	gitRepository(t, source)
+	repo := filepath.Join(dir, "repo")
+
+	s, ch, stop := startService(t, satis.ServiceParam{RepoPath: repo, Timeout: 10 * time.Second, Builder: satis.BuilderNative})
+	defer stop()
+	config := `{
+  "name": "test",
+  "homepage": "http://example.com/composer/",
+  "repositories": [
+    {"type": "vcs", "url": "` + source + `"},
+    {"type": "package", "package": {"name": "test/inline", "version": "1.0.0", "dist": {"type": "zip", "url": "http://example.com/inline.zip"}}},
+    {"packagist.org": false}
+  ]
+}`
+	ioutil.WriteFile(s.ConfigPath(), []byte(config), 0644)
+
+	jobID, _, _ := s.Rebuild()
+	if r := <-ch; !assert.NoError(t, r.Error) {
+		out, _ := s.JobLog(jobID)
+		t.Fatalf("%s", out)
+	}
+
+	var index map[string]interface{}
+	data, _ := ioutil.ReadFile(filepath.Join(repo, "packages.json"))
+	assert.NoError(t, json.Unmarshal(data, &index))
+	assert.Equal(t, map[string]interface{}{
+		"packages":           []interface{}{},
+		"metadata-url":       "/composer/p2/%package%.json",
+		"available-packages": []interface{}{"test/a", "test/inline"},
+	}, index)
+
+	versions := readMetadata(t, filepath.Join(repo, "p2/test/a.json"), "test/a")
+	assert.Equal(t, []string{"1.1.0.0-beta1", "1.0.0.0"}, versionsOf(versions))
+	assert.Equal(t, "v1.0.0", versions[1]["version"])
+	assert.Equal(t, "test", versions[1]["description"])
+	assert.Nil(t, versions[1]["config"])
+	assert.Equal(t, "git", versions[1]["source"].(map[string]interface{})["type"])
+	assert.Len(t, versions[1]["source"].(map[string]interface{})["reference"], 40)
+
+	versions = readMetadata(t, filepath.Join(repo, "p2/test/a~dev.json"), "test/a")
+	assert.Equal(t, []string{"1.9999999.9999999.9999999-dev", "dev-master"}, versionsOf(versions))
+	assert.Equal(t, "1.x-dev", versions[0]["version"])
+	assert.Equal(t, true, versions[1]["default-branch"])
+
+	versions = readMetadata(t, filepath.Join(repo, "p2/test/inline.json"), "test/inline")
+	assert.Equal(t, []string{"1.0.0.0"}, versionsOf(versions))
+	assert.Len(t, readMetadata(t, filepath.Join(repo, "p2/test/inline~dev.json"), "test/inline"), 0)
+
+	// a partial build keeps the other packages, and a full build removes
+	// the packages no longer in the config
+	_, _, err = s.UpdatePackage(satis.PackageInfo{Name: "test/inline"})
+	assert.NoError(t, err)
+	assert.NoError(t, (<-ch).Error)
+	data, _ = ioutil.ReadFile(filepath.Join(repo, "packages.json"))
+	assert.Contains(t, string(data), `"test/a"`)
+
+	config = `{"name": "test", "homepage": "http://example.com", "repositories": [{"type": "vcs", "url": "` + source + `"}], "require": {"test/a": "*"}}`
+	ioutil.WriteFile(s.ConfigPath(), []byte(config), 0644)
+	s.Rebuild()
+	assert.NoError(t, (<-ch).Error)
+	data, _ = ioutil.ReadFile(filepath.Join(repo, "packages.json"))
+	assert.NotContains(t, string(data), `"test/inline"`)
+	assert.Contains(t, string(data), `"/p2/%package%.json"`)
+	_, err = os.Stat(filepath.Join(repo, "p2/test/inline.json"))
+	assert.True(t, os.IsNotExist(err))
+
+	// a partial build of a name no composer.json has fails
+	s.UpdatePackage(satis.PackageInfo{Name: "test/unknown"})
+	assert.EqualError(t, (<-ch).Error, "package not found in the repositories: test/unknown")
+
+	// the repositories satis is needed for
+	ioutil.WriteFile(s.ConfigPath(), []byte(`{"repositories": [{"type": "composer", "url": "https://packagist.org"}]}`), 0644)
+	s.Rebuild()
+	assert.EqualError(t, (<-ch).Error, `repositories/0: native builder does not support "composer" repositories`)
+}
+
+func TestNativeBuilderArchive(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir("", "satis-native")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "a")
+	os.Mkdir(source, 0755)
+	gitRepository(t, source)
+	repo := filepath.Join(dir, "repo")
+
+	s, ch, stop := startService(t, satis.ServiceParam{RepoPath: repo, Timeout: 10 * time.Second, Builder: satis.BuilderNative})
+	defer stop()
+	config := `{
+  "name": "test",
+  "homepage": "http://example.com/composer/",
+  "repositories": [{"type": "vcs", "url": "` + source + `"}],
+  "archive": {"directory": "dist", "skip-dev": true}
+}`
+	ioutil.WriteFile(s.ConfigPath(), []byte(config), 0644)
+	stale := filepath.Join(repo, "dist/test/b/test-b-1.0.0-0000.zip")
+	os.MkdirAll(filepath.Dir(stale), 0755)
+	ioutil.WriteFile(stale, nil, 0644)
+
+	jobID, _, _ := s.Rebuild()
+	if r := <-ch; !assert.NoError(t, r.Error) {
+		out, _ := s.JobLog(jobID)
+		t.Fatalf("%s", out)
+	}
+
+	versions := readMetadata(t, filepath.Join(repo, "p2/test/a.json"), "test/a")
+	if !assert.Len(t, versions, 2) {
+		t.FailNow()
+	}
+	commit := versions[1]["source"].(map[string]interface{})["reference"].(string)
+	name := "test-a-v1.0.0-" + commit + ".zip"
+	assert.Equal(t, map[string]interface{}{
+		"type":      "zip",
+		"url":       "http://example.com/composer/dist/test/a/" + name,
+		"reference": commit,
+		"shasum":    sha1File(t, filepath.Join(repo, "dist/test/a", name)),
+	}, versions[1]["dist"])
+	assert.NotNil(t, versions[0]["dist"])
+	for _, v := range readMetadata(t, filepath.Join(repo, "p2/test/a~dev.json"), "test/a") {
+		assert.Nil(t, v["dist"])
+	}
+	_, err = os.Stat(stale)
+	assert.True(t, os.IsNotExist(err))
+
+	// a partial build replaces the archives of the package only
+	ioutil.WriteFile(stale, nil, 0644)
+	runGit(t, source, "tag", "-d", "1.1.0-beta1")
+	runGit(t, source, "tag", "v1.2.0")
+	_, _, err = s.UpdatePackage(satis.PackageInfo{Name: "test/a"})
+	assert.NoError(t, err)
+	assert.NoError(t, (<-ch).Error)
+	names, _ := filepath.Glob(filepath.Join(repo, "dist/test/a/*"))
+	assert.Equal(t, []string{
+		filepath.Join(repo, "dist/test/a", name),
+		filepath.Join(repo, "dist/test/a", "test-a-v1.2.0-"+commit+".zip"),
+	}, names)
+	_, err = os.Stat(stale)
+	assert.NoError(t, err)
+}
+
+func TestNativeBuilderInvalidName(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir("", "satis-native")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "a")
+	os.Mkdir(source, 0755)
+	gitRepository(t, source)
+	escaped := filepath.Join(dir, "b")
+	os.Mkdir(escaped, 0755)
+	ioutil.WriteFile(filepath.Join(escaped, "composer.json"), []byte(`{"name": "a/../../../escaped"}`), 0644)
+	runGit(t, escaped, "init", "-q")
+	runGit(t, escaped, "symbolic-ref", "HEAD", "refs/heads/master")
+	runGit(t, escaped, "add", "composer.json")
+	runGit(t, escaped, "commit", "-q", "-m", "first")
+	runGit(t, escaped, "tag", "v1.0.0")
+	repo := filepath.Join(dir, "repo")
+
+	s, ch, stop := startService(t, satis.ServiceParam{RepoPath: repo, Timeout: 10 * time.Second, Builder: satis.BuilderNative})
+	defer stop()
+	config := `{
+  "name": "test",
+  "homepage": "http://example.com/composer/",
+  "repositories": [
+    {"type": "vcs", "url": "` + source + `"},
+    {"type": "vcs", "url": "` + escaped + `"},
+    {"type": "package", "package": {"name": "a/../../../escaped", "version": "1.0.0"}}
+  ],
+  "archive": {"directory": "dist"}
+}`
+	ioutil.WriteFile(s.ConfigPath(), []byte(config), 0644)
+
+	// the packages of invalid names are skipped, and the others are built
+	jobID, _, _ := s.Rebuild()
+	if r := <-ch; !assert.NoError(t, r.Error) {
+		out, _ := s.JobLog(jobID)
+		t.Fatalf("%s", out)
+	}
+	out, _ := s.JobLog(jobID)
+	assert.Contains(t, string(out), `Skipped `+escaped+`: invalid package name "a/../../../escaped"`)
+	assert.Contains(t, string(out), `Skipped package "a/../../../escaped": invalid package name`)
+	data, _ := ioutil.ReadFile(filepath.Join(repo, "packages.json"))
+	assert.Contains(t, string(data), `"test/a"`)
+	assert.NotContains(t, string(data), "escaped")
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasPrefix(info.Name(), "escaped") {
+			t.Errorf("%s written", path)
+		}
+		return nil
+	})
+}
+
+func sha1File(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func readMetadata(t *testing.T, path, name string) []map[string]interface{} {
+	var metadata struct {
+		Packages map[string][]map[string]interface{} `json:"packages"`
+	}
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, &metadata))
+	return metadata.Packages[name]
+}
+
+func versionsOf(versions []map[string]interface{}) []string {
+	var normalized []string
+	for _, v := range versions {
+		normalized = append(normalized, v["version_normalized"].(string))
+	}
+	return normalized
+}
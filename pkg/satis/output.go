@@ -0,0 +1,76 @@
+package satis
+
+import (
+	"sync"
+)
+
+// maxJobOutputSize is the maximum bytes of satis output kept for a job.
+const maxJobOutputSize = 1 << 20
+
+// jobOutput keeps the tail of the satis output of a job and
+// fans it out to the subscribers.
+type jobOutput struct {
+	mu          sync.Mutex
+	data        []byte
+	truncated   bool
+	subscribers []chan JobEvent
+}
+
+// Write appends data, dropping the oldest bytes beyond maxJobOutputSize.
+// It never blocks on slow subscribers; they miss the data instead.
+func (o *jobOutput) Write(data []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.data = append(o.data, data...)
+	if over := len(o.data) - maxJobOutputSize; 0 < over {
+		o.data = append(o.data[:0], o.data[over:]...)
+		o.truncated = true
+	}
+
+	if 0 < len(o.subscribers) {
+		chunk := append([]byte(nil), data...)
+		for _, ch := range o.subscribers {
+			select {
+			case ch <- JobEvent{Type: JobEventOutput, Output: chunk}:
+			default:
+			}
+		}
+	}
+	return len(data), nil
+}
+
+// Bytes returns a copy of the kept output.
+func (o *jobOutput) Bytes() []byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.bytes()
+}
+
+func (o *jobOutput) bytes() []byte {
+	var data []byte
+	if o.truncated {
+		data = append(data, "(truncated)\n"...)
+	}
+	return append(data, o.data...)
+}
+
+// subscribe registers ch to receive the further output and
+// returns the output so far.
+func (o *jobOutput) subscribe(ch chan JobEvent) []byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.subscribers = append(o.subscribers, ch)
+	return o.bytes()
+}
+
+func (o *jobOutput) unsubscribe(ch chan JobEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i, c := range o.subscribers {
+		if c == ch {
+			o.subscribers = append(o.subscribers[:i], o.subscribers[i+1:]...)
+			return
+		}
+	}
+}
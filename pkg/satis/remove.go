@@ -0,0 +1,95 @@
+package satis
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidPackageName is returned for a package name which Composer does not accept.
+var ErrInvalidPackageName = errors.New("invalid package name")
+
+// packageNamePattern is the package name format Composer accepts.
+var packageNamePattern = regexp.MustCompile(`^[a-z0-9]([_.-]?[a-z0-9]+)*/[a-z0-9](([_.]?|-{0,2})[a-z0-9]+)*$`)
+
+// ValidPackageName determines whether the name is a valid Composer package name.
+func ValidPackageName(name string) bool {
+	return packageNamePattern.MatchString(name)
+}
+
+// RemovePackage requests removing the package from the satis config file,
+// followed by a full rebuild which drops the package from the repository.
+// The package is identified by its URL, or by its name when the URL is empty.
+func (s *service) RemovePackage(pkg PackageInfo) (string, chan ServiceResult, error) {
+	if pkg.Name != "" && !ValidPackageName(pkg.Name) {
+		return "", nil, ErrInvalidPackageName
+	}
+	if pkg.Name == "" && pkg.URL == "" {
+		return "", nil, ErrInvalidPackageName
+	}
+
+	job := s.jobs.add(JobKindRemove, &pkg)
+	s.journalQueued(job)
+	ch := make(chan ServiceResult, 1)
+	select {
+	case s.cmdRebuild <- requestRebuild{JobID: job.ID, Result: ch, Remove: []PackageInfo{pkg}}:
+		return job.ID, ch, nil
+	default:
+		s.rejectFull(job.ID)
+		return "", nil, ErrQueueFull
+	}
+}
+
+// removePackages drops the packages from the satis config, rebuilds the
+// repository and deletes the files left for the packages.
+func (s *service) removePackages(ctx context.Context, req requestRebuild) error {
+	err := s.changeConfig([]string{req.JobID}, packageSources(req.Remove, "remove"), func() error {
+		return RemoveConfig(s.configPath, req.Remove)
+	})
+	if err != nil {
+		return err
+	}
+
+	out := s.jobs.output(req.JobID)
+	err = s.withRetry(ctx, []string{req.JobID}, out, func(ctx context.Context) error {
+		return s.rebuild(ctx, out)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, pkg := range req.Remove {
+		if pkg.Name == "" {
+			continue
+		}
+		if err := purgePackage(s.repoPath, pkg.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgePackage deletes the metadata and the archives of the package from
+// the satis output directory. A full rebuild leaves them behind.
+func purgePackage(repoPath, name string) error {
+	if !ValidPackageName(name) {
+		return ErrInvalidPackageName
+	}
+
+	var paths []string
+	for _, pattern := range []string{"p/" + name + "$*.json", "p/" + name + ".json", "p2/" + name + ".json", "p2/" + name + "~dev.json"} {
+		matches, _ := filepath.Glob(filepath.Join(repoPath, filepath.FromSlash(pattern)))
+		paths = append(paths, matches...)
+	}
+	paths = append(paths, filepath.Join(repoPath, "dist", filepath.FromSlash(name)))
+
+	for _, path := range paths {
+		if err := os.RemoveAll(path); err != nil {
+			return errors.Errorf("failed to purge package %s: %s", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package satis
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// HasPackage determines whether the satis output directory has the package
+// built, in the "p2" metadata, or in packages.json and its includes.
+func HasPackage(repoPath, name string) bool {
+	if !ValidPackageName(name) {
+		return false
+	}
+	for _, suffix := range []string{".json", "~dev.json"} {
+		if _, err := os.Stat(filepath.Join(repoPath, "p2", filepath.FromSlash(name)+suffix)); err == nil {
+			return true
+		}
+	}
+
+	var root struct {
+		Packages json.RawMessage            `json:"packages"`
+		Includes map[string]json.RawMessage `json:"includes"`
+	}
+	data, err := ioutil.ReadFile(filepath.Join(repoPath, "packages.json"))
+	if err != nil || json.Unmarshal(data, &root) != nil {
+		return false
+	}
+	if hasPackageEntry(root.Packages, name) {
+		return true
+	}
+	for include := range root.Includes {
+		var file struct {
+			Packages json.RawMessage `json:"packages"`
+		}
+		data, err := ioutil.ReadFile(filepath.Join(repoPath, filepath.FromSlash(include)))
+		if err == nil && json.Unmarshal(data, &file) == nil && hasPackageEntry(file.Packages, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPackageEntry determines whether the "packages" object has the package.
+// Composer 2 repositories have an empty array there.
+func hasPackageEntry(packages json.RawMessage, name string) bool {
+	var entries map[string]json.RawMessage
+	if json.Unmarshal(packages, &entries) != nil {
+		return false
+	}
+	_, ok := entries[name]
+	return ok
+}
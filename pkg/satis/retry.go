@@ -0,0 +1,80 @@
+package satis
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os/exec"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy determines how a failed satis build of a job is retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of satis builds tried for a job, including
+	// the first one. Zero or one disables retrying.
+	MaxAttempts int
+	// BackoffBase is the delay before the first retry. It doubles on each retry.
+	BackoffBase time.Duration
+	// Jitter randomizes the delay by the ratio, from 0 to 1.
+	Jitter float64
+	// RetryTimeout lets a satis build killed by the timeout be retried too.
+	RetryTimeout bool
+}
+
+// maxBackoffShift caps the exponential growth of the backoff.
+const maxBackoffShift = 10
+
+// retryable determines whether the failed attempt should be retried.
+func (p RetryPolicy) retryable(attempt int, err error) bool {
+	if err == nil || p.MaxAttempts <= attempt {
+		return false
+	}
+	if isTimeout(err) {
+		return p.RetryTimeout
+	}
+	// a non-zero exit of satis; other errors such as a missing executable
+	// will not be cured by retrying
+	_, ok := errors.Cause(err).(*exec.ExitError)
+	return ok
+}
+
+// backoff returns the delay before the retry following the attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	shift := uint(attempt - 1)
+	if maxBackoffShift < shift {
+		shift = maxBackoffShift
+	}
+	delay := float64(p.BackoffBase << shift)
+	if 0 < p.Jitter {
+		delay *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay)
+}
+
+// withRetry runs the satis build fn with the timeout, retrying it on failure
+// as the retry policy allows. Each attempt is counted on the jobs.
+func (s *service) withRetry(ctx context.Context, jobIDs []string, out io.Writer, fn func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		for _, id := range jobIDs {
+			s.jobs.attempt(id)
+		}
+
+		err := s.withTimeout(ctx, fn)
+		if ctx.Err() != nil || !s.retry.retryable(attempt, err) {
+			return err
+		}
+
+		delay := s.retry.backoff(attempt)
+		fmt.Fprintf(out, "attempt %d/%d failed: %v; retry in %v\n", attempt, s.retry.MaxAttempts, err, delay)
+		s.errLog.Printf("jobs %v: attempt %d/%d failed: %v; retry in %v", jobIDs, attempt, s.retry.MaxAttempts, err, delay)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package satis
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Schedule is a periodic build. It runs a full rebuild, or partial builds of
+// Packages when specified.
+type Schedule struct {
+	// Spec is a 5 field cron expression such as "0 3 * * *".
+	Spec string `json:"spec"`
+	// Packages are the names of the packages to build.
+	Packages []string `json:"packages,omitempty"`
+}
+
+// ScheduleStatus represents the state of a schedule.
+type ScheduleStatus struct {
+	Schedule
+	NextRun *time.Time `json:"next_run,omitempty"`
+	LastRun *time.Time `json:"last_run,omitempty"`
+	// LastJobIDs are the jobs queued on the last run.
+	LastJobIDs []string `json:"last_job_ids,omitempty"`
+	// Skipped is the number of runs skipped because of equivalent jobs
+	// already queued or running.
+	Skipped int `json:"skipped"`
+}
+
+// ParseSchedules parses the schedules separated by ";". Each schedule is
+// a cron expression optionally followed by package names, such as
+// "0 3 * * *; */30 * * * * vendor/a vendor/b".
+func ParseSchedules(value string) ([]Schedule, error) {
+	var schedules []Schedule
+	for _, entry := range strings.Split(value, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < len(cronFields) {
+			return nil, errors.Errorf("schedule %q must start with a cron expression", strings.TrimSpace(entry))
+		}
+
+		schedule := Schedule{
+			Spec:     strings.Join(fields[:len(cronFields)], " "),
+			Packages: fields[len(cronFields):],
+		}
+		if _, err := parseCron(schedule.Spec); err != nil {
+			return nil, err
+		}
+		for i, name := range schedule.Packages {
+			name = strings.ToLower(name)
+			if !ValidPackageName(name) {
+				return nil, errors.Errorf("schedule %q: invalid package name %q", strings.TrimSpace(entry), name)
+			}
+			schedule.Packages[i] = name
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, nil
+}
+
+// scheduler enqueues the builds of the schedules on time.
+type scheduler struct {
+	mu       sync.Mutex
+	specs    []cronSpec
+	statuses []ScheduleStatus
+}
+
+func newScheduler(schedules []Schedule, now time.Time) (*scheduler, error) {
+	sc := &scheduler{}
+	for _, schedule := range schedules {
+		spec, err := parseCron(schedule.Spec)
+		if err != nil {
+			return nil, err
+		}
+		status := ScheduleStatus{Schedule: schedule}
+		if next := spec.next(now); !next.IsZero() {
+			status.NextRun = &next
+		}
+		sc.specs = append(sc.specs, spec)
+		sc.statuses = append(sc.statuses, status)
+	}
+	return sc, nil
+}
+
+// list returns copies of the schedule statuses.
+func (sc *scheduler) list() []ScheduleStatus {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	statuses := make([]ScheduleStatus, len(sc.statuses))
+	copy(statuses, sc.statuses)
+	return statuses
+}
+
+// due returns the indexes of the schedules to run at now and advances them,
+// and the time of the nearest next run.
+func (sc *scheduler) due(now time.Time) ([]int, time.Time) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	var indexes []int
+	var nearest time.Time
+	for i := range sc.statuses {
+		status := &sc.statuses[i]
+		if status.NextRun == nil {
+			continue
+		}
+		if !now.Before(*status.NextRun) {
+			indexes = append(indexes, i)
+			status.LastRun = status.NextRun
+			status.NextRun = nil
+			if next := sc.specs[i].next(now); !next.IsZero() {
+				status.NextRun = &next
+			}
+		}
+		if status.NextRun != nil && (nearest.IsZero() || status.NextRun.Before(nearest)) {
+			nearest = *status.NextRun
+		}
+	}
+	return indexes, nearest
+}
+
+// ran records the result of the run of the schedule.
+func (sc *scheduler) ran(i int, jobIDs []string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if len(jobIDs) == 0 {
+		sc.statuses[i].Skipped++
+		return
+	}
+	sc.statuses[i].LastJobIDs = jobIDs
+}
+
+// runSchedules enqueues the scheduled builds until ctx is done.
+func (s *service) runSchedules(ctx context.Context) {
+	for {
+		indexes, next := s.scheduler.due(time.Now())
+		for _, i := range indexes {
+			s.scheduler.ran(i, s.runSchedule(s.scheduler.statuses[i].Schedule))
+		}
+		if next.IsZero() {
+			return
+		}
+
+		timer := time.NewTimer(next.Sub(time.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// runSchedule queues the builds of the schedule unless equivalent jobs are
+// already queued or running, and returns the queued job IDs.
+func (s *service) runSchedule(schedule Schedule) []string {
+	if s.jobs.pending(JobKindRebuild, "") {
+		s.stdLog.Printf("schedule %q: skipped for the pending rebuild", schedule.Spec)
+		return nil
+	}
+
+	var jobIDs []string
+	if len(schedule.Packages) == 0 {
+		jobID, _, err := s.Rebuild()
+		if err != nil {
+			s.errLog.Printf("schedule %q: %v", schedule.Spec, err)
+			return nil
+		}
+		return append(jobIDs, jobID)
+	}
+
+	for _, name := range schedule.Packages {
+		if s.jobs.pending(JobKindPartial, name) {
+			s.stdLog.Printf("schedule %q: %v skipped for the pending job", schedule.Spec, name)
+			continue
+		}
+		jobID, _, err := s.UpdatePackage(PackageInfo{Name: name})
+		if err != nil {
+			s.errLog.Printf("schedule %q: %v: %v", schedule.Spec, name, err)
+			continue
+		}
+		jobIDs = append(jobIDs, jobID)
+	}
+	return jobIDs
+}
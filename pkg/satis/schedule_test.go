@@ -0,0 +1,141 @@
+package satis_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/reedom/satishub/pkg/satis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCronNext(t *testing.T) {
+	base := time.Date(2018, 3, 12, 15, 26, 45, 0, time.UTC) // Monday
+	cases := []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2018, 3, 12, 15, 27, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2018, 3, 13, 3, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2018, 3, 12, 15, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2018, 3, 12, 17, 0, 0, 0, time.UTC)},
+		{"30 2 1 * *", time.Date(2018, 4, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2018, 3, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2018, 3, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * 12 1,3", time.Date(2018, 12, 3, 0, 0, 0, 0, time.UTC)},
+		// either day of month or day of week
+		{"0 0 1 * 3", time.Date(2018, 3, 14, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, c := range cases {
+		next, err := satis.CronNext(c.expr, base)
+		if assert.NoError(t, err, c.expr) {
+			assert.Equal(t, c.next, next, c.expr)
+		}
+	}
+
+	// zones off by a fraction of hour
+	for _, loc := range []*time.Location{time.FixedZone("IST", 5*3600+1800), time.FixedZone("NST", -(3*3600 + 1800))} {
+		base := time.Date(2018, 3, 12, 15, 26, 45, 0, loc)
+		next, err := satis.CronNext("0 3 * * *", base)
+		if assert.NoError(t, err, loc.String()) {
+			assert.Equal(t, time.Date(2018, 3, 13, 3, 0, 0, 0, loc), next, loc.String())
+		}
+		next, _ = satis.CronNext("45 */2 * * *", base)
+		assert.Equal(t, time.Date(2018, 3, 12, 16, 45, 0, 0, loc), next, loc.String())
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		_, err := satis.CronNext(expr, base)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestParseSchedules(t *testing.T) {
+	schedules, err := satis.ParseSchedules("0 3 * * *; */30 * * * * vendor/a Vendor/B;")
+	assert.NoError(t, err)
+	assert.Equal(t, []satis.Schedule{
+		{Spec: "0 3 * * *", Packages: []string{}},
+		{Spec: "*/30 * * * *", Packages: []string{"vendor/a", "vendor/b"}},
+	}, schedules)
+
+	_, err = satis.ParseSchedules("0 3 * *")
+	assert.Error(t, err)
+	_, err = satis.ParseSchedules("0 3 * * * ; 0 25 * * *")
+	assert.Error(t, err)
+	_, err = satis.ParseSchedules("0 3 * * * --no-interaction")
+	assert.Error(t, err)
+}
+
+func TestSchedules(t *testing.T) {
+	satisPath := slowSatis(t)
+	defer os.Remove(satisPath)
+
+	schedules := []satis.Schedule{
+		{Spec: "0 3 * * *"},
+		{Spec: "*/30 * * * *", Packages: []string{"vendor/a", "vendor/b"}},
+	}
+	s, ch, stop := startService(t, satis.ServiceParam{SatisPath: satisPath, Timeout: 20 * time.Second, Schedules: schedules})
+	defer stop()
+	go func() {
+		for range ch {
+		}
+	}()
+
+	statuses := s.Schedules()
+	if assert.Len(t, statuses, 2) {
+		assert.Equal(t, "0 3 * * *", statuses[0].Spec)
+		if assert.NotNil(t, statuses[0].NextRun) {
+			assert.True(t, statuses[0].NextRun.After(time.Now()))
+			assert.Equal(t, 3, statuses[0].NextRun.Hour())
+		}
+	}
+
+	// a package with a pending job is skipped
+	jobIDs := satis.RunSchedule(s, schedules[1])
+	assert.Len(t, jobIDs, 2)
+	waitState(t, s, jobIDs[0], satis.JobRunning)
+	jobIDs = satis.RunSchedule(s, satis.Schedule{Spec: "* * * * *", Packages: []string{"vendor/a", "vendor/c"}})
+	if assert.Len(t, jobIDs, 1) {
+		job, _ := s.Job(jobIDs[0])
+		assert.Equal(t, "vendor/c", job.Package.Name)
+	}
+
+	// a pending rebuild covers everything
+	rebuild, _, _ := s.Rebuild()
+	assert.Empty(t, satis.RunSchedule(s, schedules[0]))
+	assert.Empty(t, satis.RunSchedule(s, schedules[1]))
+
+	for _, job := range s.Jobs() {
+		s.Cancel(job.ID)
+	}
+	waitState(t, s, rebuild, satis.JobCancelled)
+	assert.Len(t, satis.RunSchedule(s, schedules[0]), 1)
+}
+
+func TestCronNextDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	cases := []struct {
+		expr string
+		base time.Time
+		next time.Time
+	}{
+		// 2:00 is skipped to 3:00 on 2018-03-11
+		{"0 * * * *", time.Date(2018, 3, 11, 1, 10, 0, 0, loc), time.Date(2018, 3, 11, 3, 0, 0, 0, loc)},
+		{"30 2 * * *", time.Date(2018, 3, 10, 12, 0, 0, 0, loc), time.Date(2018, 3, 12, 2, 30, 0, 0, loc)},
+		{"0 4 * * *", time.Date(2018, 3, 11, 0, 30, 0, 0, loc), time.Date(2018, 3, 11, 4, 0, 0, 0, loc)},
+		// 1:00 is repeated on 2018-11-04
+		{"0 2 * * *", time.Date(2018, 11, 4, 0, 30, 0, 0, loc), time.Date(2018, 11, 4, 2, 0, 0, 0, loc)},
+		{"0 * * * *", time.Date(2018, 11, 4, 1, 10, 0, 0, loc), time.Date(2018, 11, 4, 1, 10, 0, 0, loc).Add(50 * time.Minute)},
+	}
+	for _, c := range cases {
+		next, err := satis.CronNext(c.expr, c.base)
+		if assert.NoError(t, err, c.expr) {
+			assert.True(t, c.next.Equal(next), "%s: %v", c.expr, next)
+		}
+	}
+}
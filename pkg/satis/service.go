@@ -2,9 +2,11 @@ package satis
 
 import (
 	"context"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,15 +18,82 @@ import (
 type Service interface {
 	Close()
 	Run(ctx context.Context) <-chan ServiceResult
-	Rebuild() chan ServiceResult
-	UpdatePackage(pkg PackageInfo) chan ServiceResult
+	// Rebuild queues a full rebuild request and returns the job ID.
+	// It returns ErrQueueFull when the queue has no room.
+	Rebuild() (string, chan ServiceResult, error)
+	// UpdatePackage queues a package update request and returns the job ID.
+	// It returns ErrInvalidPackageName for a malformed name, and ErrQueueFull
+	// when the queue has no room.
+	UpdatePackage(pkg PackageInfo) (string, chan ServiceResult, error)
+	// RemovePackage queues a package removal request and returns the job ID.
+	// It returns ErrInvalidPackageName for a malformed name, and ErrQueueFull
+	// when the queue has no room.
+	RemovePackage(pkg PackageInfo) (string, chan ServiceResult, error)
+	// Cancel cancels the queued or running job.
+	Cancel(id string) error
+
+	// Jobs returns the known jobs, the newest first.
+	Jobs() []Job
+	// Job returns the job of the ID.
+	Job(id string) (Job, bool)
+	// JobLog returns the satis output of the job.
+	JobLog(id string) ([]byte, bool)
+	// Subscribe streams the state transitions and the satis output of the job.
+	// An empty id subscribes the state transitions of all jobs.
+	Subscribe(id string) (JobSubscription, bool)
+	// Schedules returns the states of the periodic builds.
+	Schedules() []ScheduleStatus
+
+	// EditConfig runs fn, which edits the satis config at configPath, and
+	// records the change in the config history as made by source.
+	EditConfig(source string, fn func(configPath string) error) error
+	// History returns the config history, or nil when it is not kept.
+	History() *ConfigHistory
+	// Rollback queues restoring the config revision followed by a full
+	// rebuild, and returns the job ID. It returns ErrHistoryDisabled,
+	// ErrRevisionNotFound or ErrQueueFull when it can not.
+	Rollback(revision int) (string, chan ServiceResult, error)
+	// Generations returns the output generations, the newest first.
+	Generations() ([]Generation, error)
+	// SwitchGeneration queues pointing the output directory path to the
+	// generation, and returns the job ID. It returns ErrGenerationsDisabled,
+	// ErrGenerationNotFound or ErrQueueFull when it can not.
+	SwitchGeneration(id string) (string, chan ServiceResult, error)
+	// Index returns the Composer index of the satis output directory, or
+	// nil when the files are served as they are.
+	Index() *ComposerIndex
 
 	ConfigPath() string
 	RepoPath() string
 }
 
+// ErrQueueFull is returned when the service can not queue more requests.
+var ErrQueueFull = errors.New("queue full")
+
+// errServiceExit is the error of the jobs interrupted by the service exit.
+// They remain in the queue journal to run again after restart.
+var errServiceExit = errors.Wrap(ErrJobCancelled, "service exit")
+
+// defaultQueueSize is the request queue capacity used when not specified.
+const defaultQueueSize = 16
+
+type requestRebuild struct {
+	JobID  string
+	Result chan ServiceResult
+	// Remove are the packages to be removed before the rebuild.
+	Remove []PackageInfo
+	// Rollback is the config revision to be restored before the rebuild.
+	Rollback int
+	// Switch is the output generation to be switched to instead of a rebuild.
+	Switch string
+	// Update are the packages of the discarded partial builds to be updated
+	// in the config before the rebuild.
+	Update []PackageInfo
+}
+
 type requestPartial struct {
 	PackageInfo
+	JobID  string
 	Result chan ServiceResult
 }
 
@@ -39,9 +108,27 @@ type service struct {
 	errLog      *log.Logger
 	stdLog      *log.Logger
 
-	cmdRebuild chan chan ServiceResult
-	cmdPartial chan requestPartial
-	closeOnce  sync.Once
+	debounce       time.Duration
+	batchThreshold int
+	supersede      bool
+	retry          RetryPolicy
+	scheduler      *scheduler
+
+	workers  int
+	mu       sync.Mutex
+	running  map[*build]bool
+	finished chan *build
+	configMu sync.Mutex
+
+	jobs        *jobStore
+	journal     *queueJournal
+	history     *ConfigHistory
+	generations *generations
+	native      *nativeBuilder
+	index       *ComposerIndex
+	cmdRebuild  chan requestRebuild
+	cmdPartial  chan requestPartial
+	closeOnce   sync.Once
 }
 
 // ServiceParam contains parameters to NewService() call.
@@ -54,6 +141,59 @@ type ServiceParam struct {
 	ErrLog      *log.Logger
 	StdLog      *log.Logger
 	SNSTopicARN string
+	// JobHistory is the number of finished jobs to be kept. Zero means no limit.
+	JobHistory int
+	// JobMaxAge is how long finished jobs are kept. Zero means no limit.
+	JobMaxAge time.Duration
+	// Debounce is the window in which package update requests are merged
+	// into a single satis build. Zero disables merging.
+	Debounce time.Duration
+	// BatchThreshold is the number of packages in a merged build beyond which
+	// a full rebuild runs instead. Zero means no limit.
+	BatchThreshold int
+	// Supersede lets a full rebuild request cancel the running build
+	// instead of waiting for it.
+	Supersede bool
+	// Workers is the number of partial builds which run at the same time.
+	// Builds of the same package are serialized, and a full rebuild runs alone.
+	// Only the native builder runs builds in parallel; satis builds, which
+	// write the same files, run one at a time.
+	Workers int
+	// Retry is the retry policy applied to each job.
+	Retry RetryPolicy
+	// Schedules are the periodic builds.
+	Schedules []Schedule
+	// QueueSize is the number of requests of each kind, rebuild and package
+	// update, which can wait for execution.
+	QueueSize int
+	// QueuePath is the journal file path to persist the queued requests.
+	// Empty disables the persistence.
+	QueuePath string
+	// HistoryPath is the directory to keep the revisions of the satis
+	// config. Empty disables the history.
+	HistoryPath string
+	// HistoryLimit is the number of config revisions to be kept.
+	// Zero means no limit.
+	HistoryLimit int
+	// Generations is the number of satis output directories of the full
+	// rebuilds to be kept for switching back. A full rebuild then runs in
+	// a staging directory, and RepoPath becomes a symbolic link to the
+	// latest one. Zero disables it, and satis builds in RepoPath in place.
+	Generations int
+	// GenerationsPath is the directory to keep the output generations.
+	// Empty means RepoPath + ".generations".
+	GenerationsPath string
+	// Builder is the metadata builder, BuilderSatis or BuilderNative.
+	// Empty means BuilderSatis.
+	Builder string
+	// GitPath is the git command the native builder runs. Empty means "git".
+	GitPath string
+	// MirrorPath is the directory to keep the git mirrors of the native
+	// builder. Empty means RepoPath + ".mirrors".
+	MirrorPath string
+	// NoIndex disables the Composer index, which is reloaded after each
+	// successful build to serve the repository metadata.
+	NoIndex bool
 }
 
 // NewService creates service instance with the specified parameters.
@@ -67,8 +207,15 @@ func NewService(param ServiceParam) Service {
 		snsTopicARN: param.SNSTopicARN,
 		errLog:      param.ErrLog,
 		stdLog:      param.StdLog,
-		cmdRebuild:  make(chan chan ServiceResult, 16),
-		cmdPartial:  make(chan requestPartial, 16),
+
+		debounce:       param.Debounce,
+		batchThreshold: param.BatchThreshold,
+		supersede:      param.Supersede,
+		retry:          param.Retry,
+		workers:        param.Workers,
+		running:        make(map[*build]bool),
+
+		jobs: newJobStore(param.JobHistory, param.JobMaxAge),
 	}
 
 	if s.errLog == nil {
@@ -78,9 +225,98 @@ func NewService(param ServiceParam) Service {
 		s.stdLog = log.New(os.Stdout, "satis ", log.Ldate|log.Ltime)
 
 	}
+
+	if s.workers <= 0 {
+		s.workers = 1
+	}
+	s.finished = make(chan *build, s.workers+1)
+
+	var err error
+	if s.scheduler, err = newScheduler(param.Schedules, time.Now()); err != nil {
+		s.errLog.Println("schedules disabled:", err.Error())
+		s.scheduler, _ = newScheduler(nil, time.Now())
+	}
+
+	if param.HistoryPath != "" {
+		s.history, err = OpenConfigHistory(param.HistoryPath, param.HistoryLimit)
+		if err != nil {
+			s.errLog.Println("config history disabled:", err.Error())
+		}
+		s.recordConfig(nil, "startup")
+	}
+
+	switch param.Builder {
+	case "", BuilderSatis:
+	case BuilderNative:
+		mirrorPath := param.MirrorPath
+		if mirrorPath == "" {
+			mirrorPath = param.RepoPath + ".mirrors"
+		}
+		s.native = newNativeBuilder(param.GitPath, mirrorPath)
+	default:
+		s.errLog.Printf("unknown builder %q, satis is used", param.Builder)
+	}
+
+	if 0 < param.Generations {
+		dir := param.GenerationsPath
+		if dir == "" {
+			dir = param.RepoPath + ".generations"
+		}
+		s.generations, err = openGenerations(param.RepoPath, dir, param.Generations)
+		if err != nil {
+			s.errLog.Println("output generations disabled:", err.Error())
+		}
+	}
+
+	if !param.NoIndex {
+		s.index = NewComposerIndex(param.RepoPath, param.ConfigPath)
+		s.reloadIndex()
+	}
+
+	var pending []Job
+	if param.QueuePath != "" {
+		s.journal, pending, err = openJournal(param.QueuePath)
+		if err != nil {
+			s.errLog.Println("queue persistence disabled:", err.Error())
+		}
+	}
+
+	size := param.QueueSize
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	if size < len(pending) {
+		size = len(pending)
+	}
+	s.cmdRebuild = make(chan requestRebuild, size)
+	s.cmdPartial = make(chan requestPartial, size)
+	s.replay(pending)
 	return s
 }
 
+// replay queues the jobs left in the journal by the previous process.
+func (s *service) replay(pending []Job) {
+	for _, job := range pending {
+		s.jobs.restore(job)
+		ch := make(chan ServiceResult, 1)
+		switch {
+		case job.Kind == JobKindRemove && job.Package != nil:
+			s.cmdRebuild <- requestRebuild{JobID: job.ID, Result: ch, Remove: []PackageInfo{*job.Package}}
+		case job.Kind == JobKindRollback:
+			s.cmdRebuild <- requestRebuild{JobID: job.ID, Result: ch, Rollback: job.Revision}
+		case job.Kind == JobKindSwitch:
+			s.cmdRebuild <- requestRebuild{JobID: job.ID, Result: ch, Switch: job.Generation}
+		case job.Kind == JobKindRebuild || job.Package == nil:
+			s.cmdRebuild <- requestRebuild{JobID: job.ID, Result: ch}
+		default:
+			s.cmdPartial <- requestPartial{*job.Package, job.ID, ch}
+		}
+	}
+	if 0 < len(pending) {
+		s.stdLog.Printf("replay %d queued jobs", len(pending))
+	}
+}
+
 // Close closes the service.
 func (s *service) Close() {
 	s.closeOnce.Do(func() {
@@ -99,80 +335,182 @@ func (s *service) RepoPath() string {
 	return s.repoPath
 }
 
+// Jobs returns the known jobs, the newest first.
+func (s *service) Jobs() []Job {
+	return s.jobs.list()
+}
+
+// Job returns the job of the ID.
+func (s *service) Job(id string) (Job, bool) {
+	return s.jobs.get(id)
+}
+
+// JobLog returns the satis output of the job.
+func (s *service) JobLog(id string) ([]byte, bool) {
+	return s.jobs.log(id)
+}
+
+// Subscribe streams the state transitions and the satis output of the job.
+// An empty id subscribes the state transitions of all jobs.
+func (s *service) Subscribe(id string) (JobSubscription, bool) {
+	return s.jobs.subscribe(id)
+}
+
+// Schedules returns the states of the periodic builds.
+func (s *service) Schedules() []ScheduleStatus {
+	return s.scheduler.list()
+}
+
 // Run starts the service.
 func (s *service) Run(ctx context.Context) <-chan ServiceResult {
 	result := make(chan ServiceResult)
+	go s.runSchedules(ctx)
 
 	go func() {
 		s.notifyService("satishub service start")
+		// rebuild is the rebuild request waiting for the running builds to end,
+		// waiting are the partial build batches waiting for a worker, and
+		// collect gathers the next batch within the debounce window.
+		var rebuild *requestRebuild
+		var waiting [][]requestPartial
+		collect := partialCollector{window: s.debounce}
 		defer func() {
 			if s.debug {
 				s.stdLog.Print("service close")
 			}
-			s.discardCommands()
+			s.stopBuilds(errServiceExit)
+			if rebuild != nil {
+				s.discardRequest(rebuild.JobID, rebuild.Result, errServiceExit)
+			}
+			s.discardWaiting(collect.flush(waiting), errServiceExit)
+			s.discardCommands(errServiceExit)
+			s.journal.Close()
 			s.notifyService("satishub service exit!")
 			close(result)
 		}()
 
 		for {
+			if rebuild != nil && len(s.runningBuilds()) == 0 {
+				req := *rebuild
+				rebuild = nil
+				s.startBuild(ctx, &build{jobIDs: []string{req.JobID}, exclusive: true}, func(ctx context.Context, b *build) {
+					s.runRebuild(ctx, b, req, result)
+				})
+			}
+			if rebuild == nil {
+				waiting = s.startPartials(ctx, waiting, result)
+			}
+
 			if s.debug {
 				s.stdLog.Print("wait for command...")
 			}
+
+			// a pending rebuild holds the following requests back, and
+			// partial build requests are accepted only when a worker is free
+			// or a batch is being collected
+			cmdRebuild, cmdPartial := s.cmdRebuild, s.cmdPartial
+			if rebuild != nil {
+				cmdRebuild, cmdPartial = nil, nil
+			} else if !collect.collecting() && !s.acceptPartial(len(waiting)) {
+				cmdPartial = nil
+			}
+
 			select {
 			case <-ctx.Done():
 				return
-			case ch, ok := <-s.cmdRebuild:
+			case b := <-s.finished:
+				s.endBuild(b)
+			case <-collect.done():
+				waiting = collect.flush(waiting)
+			case req, ok := <-cmdRebuild:
 				if !ok {
 					return
 				}
 				if s.debug {
-					s.stdLog.Println("cmd rebuild")
+					s.stdLog.Println("cmd rebuild", req.JobID)
+				}
+				if s.jobs.cancelled(req.JobID) {
+					s.reply(result, req.JobID, req.Result, ErrJobCancelled)
+					continue
 				}
-				s.discardCommands()
-				// TODO make it possible to cancel previous Execute command
-				ctxCmd, cancel := context.WithTimeout(ctx, s.timeout)
-				err := s.rebuild(ctxCmd)
-				if err == context.DeadlineExceeded {
-					err = errors.Wrap(err, "satis command execution timeout")
+				if s.supersede {
+					s.stopBuilds(errors.Wrapf(ErrJobCancelled, "superseded by job %v", req.JobID))
 				}
-				cancel()
-				r := ServiceResult{Error: err}
-				result <- r
-				ch <- r
-				close(ch)
-			case req, ok := <-s.cmdPartial:
+				// a removal, a rollback and a switch keep the queued
+				// updates, which a rebuild covers, taking over their
+				// changes of the config
+				if len(req.Remove) == 0 && req.Rollback == 0 && req.Switch == "" {
+					reason := errors.Errorf("discarded by rebuild job %v", req.JobID)
+					discarded := s.discardWaiting(collect.flush(waiting), reason)
+					waiting = nil
+					discarded = append(discarded, s.discardCommands(reason)...)
+					req.Update = configUpdates(mergePackages(discarded))
+				}
+				rebuild = &req
+			case req, ok := <-cmdPartial:
 				if !ok {
 					return
 				}
 				if s.debug {
-					s.stdLog.Println("cmd partial build")
-				}
-				err := s.notifyPartialBuild(req.PackageInfo, "start", nil)
-				if err != nil {
-					s.errLog.Println("notify error", err.Error())
+					s.stdLog.Println("cmd partial build", req.JobID)
 				}
-				err = s.updatePackage(ctx, req.PackageInfo)
-				if err != nil {
-					s.notifyPartialBuild(req.PackageInfo, "error", err)
-					if err == context.DeadlineExceeded {
-						err = errors.Wrap(err, "satis command execution timeout")
-					}
+				if s.debounce <= 0 {
+					waiting = append(waiting, []requestPartial{req})
 				} else {
-					err = s.notifyPartialBuild(req.PackageInfo, "completed", nil)
-					if err != nil {
-						s.errLog.Println("notify error", err.Error())
-					}
+					collect.add(req)
 				}
-				r := ServiceResult{Error: err}
-				result <- r
-				req.Result <- r
-				close(req.Result)
 			}
 		}
 	}()
 	return result
 }
 
+// runRebuild runs satis full rebuild for the request.
+func (s *service) runRebuild(ctx context.Context, b *build, req requestRebuild, result chan<- ServiceResult) {
+	if !s.jobs.start(req.JobID) {
+		s.reply(result, req.JobID, req.Result, ErrJobCancelled)
+		return
+	}
+
+	var err error
+	if 0 < len(req.Remove) {
+		err = s.removePackages(ctx, req)
+	} else if 0 < req.Rollback {
+		err = s.rollbackConfig(ctx, req)
+	} else if req.Switch != "" {
+		err = s.switchGeneration(req)
+	} else {
+		if 0 < len(req.Update) {
+			err = s.changeConfig([]string{req.JobID}, packageSources(req.Update, "update"), func() error {
+				return UpdateConfig(s.configPath, req.Update)
+			})
+		}
+		if err == nil {
+			out := s.jobs.output(req.JobID)
+			err = s.withRetry(ctx, []string{req.JobID}, out, func(ctx context.Context) error {
+				return s.rebuild(ctx, out)
+			})
+		}
+	}
+	err = b.result(err)
+	if err == nil {
+		s.reloadIndex()
+	}
+	s.jobs.finish(req.JobID, err)
+	s.reply(result, req.JobID, req.Result, err)
+}
+
+// reply sends the job result to both of the service stream and the requester.
+func (s *service) reply(result chan<- ServiceResult, jobID string, ch chan ServiceResult, err error) {
+	if err != errServiceExit {
+		s.journalDone(jobID)
+	}
+	r := ServiceResult{JobID: jobID, Error: err}
+	ch <- r
+	close(ch)
+	result <- r
+}
+
 type snsTopicPartial struct {
 	Event   string      `json:"type"`
 	Package PackageInfo `json:"package"`
@@ -222,65 +560,166 @@ func (s *service) notifyService(msg string) error {
 }
 
 // Rebuild requests satis full rebuild.
-func (s *service) Rebuild() chan ServiceResult {
-	ch := make(chan ServiceResult)
-	s.cmdRebuild <- ch
-	return ch
+// The result channel is buffered so that the caller may leave it unread.
+func (s *service) Rebuild() (string, chan ServiceResult, error) {
+	job := s.jobs.add(JobKindRebuild, nil)
+	s.journalQueued(job)
+	ch := make(chan ServiceResult, 1)
+	select {
+	case s.cmdRebuild <- requestRebuild{JobID: job.ID, Result: ch}:
+		return job.ID, ch, nil
+	default:
+		s.rejectFull(job.ID)
+		return "", nil, ErrQueueFull
+	}
 }
 
 // UpdatePackage requests updating the satis config file and partial building.
-func (s *service) UpdatePackage(pkg PackageInfo) chan ServiceResult {
-	ch := make(chan ServiceResult)
-	s.cmdPartial <- requestPartial{pkg, ch}
-	return ch
+// The result channel is buffered so that the caller may leave it unread.
+func (s *service) UpdatePackage(pkg PackageInfo) (string, chan ServiceResult, error) {
+	pkg.Name = strings.ToLower(pkg.Name)
+	if pkg.Name != "" && !ValidPackageName(pkg.Name) {
+		return "", nil, ErrInvalidPackageName
+	}
+
+	job := s.jobs.add(JobKindPartial, &pkg)
+	s.journalQueued(job)
+	ch := make(chan ServiceResult, 1)
+	select {
+	case s.cmdPartial <- requestPartial{pkg, job.ID, ch}:
+		return job.ID, ch, nil
+	default:
+		s.rejectFull(job.ID)
+		return "", nil, ErrQueueFull
+	}
 }
 
-func (s *service) updatePackage(ctx context.Context, pkg PackageInfo) error {
-	err := UpdateConfig(s.configPath, []PackageInfo{pkg})
-	if err != nil {
-		return err
+// rejectFull marks the job which could not be queued.
+func (s *service) rejectFull(jobID string) {
+	s.errLog.Printf("job %v: discarded: %v", jobID, ErrQueueFull)
+	s.jobs.discard(jobID, ErrQueueFull)
+	s.journalDone(jobID)
+}
+
+func (s *service) journalQueued(job Job) {
+	if err := s.journal.queued(job); err != nil {
+		s.errLog.Println(err.Error())
+	}
+}
+
+func (s *service) journalDone(jobID string) {
+	if err := s.journal.done(jobID); err != nil {
+		s.errLog.Println(err.Error())
 	}
+}
 
+// withTimeout runs fn with the satis execution timeout.
+func (s *service) withTimeout(ctx context.Context, fn func(ctx context.Context) error) error {
 	ctxCmd, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
 
-	if pkg.Name != "" {
-		return s.partialBuild(ctxCmd, pkg.Name)
+	err := fn(ctxCmd)
+	if err != nil && ctxCmd.Err() == context.DeadlineExceeded {
+		return errors.Wrap(context.DeadlineExceeded, "satis command execution timeout")
 	}
-	return s.rebuild(ctxCmd)
+	return err
 }
 
-func (s *service) discardCommands() {
+// isTimeout determines whether the error comes from the satis execution timeout.
+func isTimeout(err error) bool {
+	return errors.Cause(err) == context.DeadlineExceeded
+}
+
+// discardCommands drops the queued requests, replying reason as their results.
+// It returns the dropped partial build requests which have not been cancelled.
+func (s *service) discardCommands(reason error) []requestPartial {
+	var discarded []requestPartial
+	cmdRebuild, cmdPartial := s.cmdRebuild, s.cmdPartial
 	for {
 		select {
-		case ch, ok := <-s.cmdRebuild:
-			if ok {
-				close(ch)
+		case req, ok := <-cmdRebuild:
+			if !ok {
+				cmdRebuild = nil
+				continue
+			}
+			s.discardRequest(req.JobID, req.Result, reason)
+		case req, ok := <-cmdPartial:
+			if !ok {
+				cmdPartial = nil
+				continue
 			}
-		case cmd, ok := <-s.cmdPartial:
-			if ok {
-				close(cmd.Result)
+			if !s.jobs.cancelled(req.JobID) {
+				discarded = append(discarded, req)
 			}
+			s.discardRequest(req.JobID, req.Result, reason)
 		default:
-			return
+			return discarded
 		}
 	}
 }
 
-func (s *service) rebuild(ctx context.Context) error {
-	command := exec.CommandContext(ctx, s.satisPath, "build", s.configPath, s.repoPath)
-	command.Stdout = logWriter{s.stdLog}
-	command.Stderr = logWriter{s.errLog}
-	return command.Run()
+// discardRequest drops the request, replying reason as its result.
+// The jobs dropped by the service exit stay in the journal.
+func (s *service) discardRequest(jobID string, ch chan ServiceResult, reason error) {
+	s.jobs.discard(jobID, reason)
+	if reason != errServiceExit {
+		s.journalDone(jobID)
+	}
+	ch <- ServiceResult{JobID: jobID, Error: reason}
+	close(ch)
+}
+
+func (s *service) rebuild(ctx context.Context, out io.Writer) error {
+	if s.generations != nil {
+		return s.buildGeneration(ctx, out)
+	}
+	return s.runBuilder(ctx, s.repoPath, nil, out)
+}
+
+func (s *service) partialBuild(ctx context.Context, targetPackages []string, out io.Writer) error {
+	return s.runBuilder(ctx, s.repoPath, targetPackages, out)
 }
 
-func (s *service) partialBuild(ctx context.Context, targetPackage string) error {
-	command := exec.CommandContext(ctx, s.satisPath, "build", s.configPath, s.repoPath, targetPackage)
-	command.Stdout = logWriter{s.stdLog}
-	command.Stderr = logWriter{s.errLog}
+// runBuilder builds the target packages, or all packages when there is
+// none, into outputDir with satis or the native builder. After a full build
+// with the "archive" config, satis purges the archives no longer referred.
+func (s *service) runBuilder(ctx context.Context, outputDir string, targetPackages []string, out io.Writer) error {
+	if s.native != nil {
+		return s.native.build(ctx, s.configPath, outputDir, targetPackages, out)
+	}
+	args := []string{"build", s.configPath, outputDir}
+	if 0 < len(targetPackages) {
+		// the names are never taken for options
+		args = append(append(args, "--"), targetPackages...)
+	}
+	command := exec.CommandContext(ctx, s.satisPath, args...)
+	s.setOutput(command, out)
+	if err := command.Run(); err != nil {
+		return err
+	}
+
+	if 0 < len(targetPackages) {
+		return nil
+	}
+	if config, err := ReadConfig(s.configPath); err != nil || !hasArchive(config) {
+		return nil
+	}
+	command = exec.CommandContext(ctx, s.satisPath, "purge", s.configPath, outputDir)
+	s.setOutput(command, out)
 	return command.Run()
 }
 
+// setOutput routes the command output into the job output.
+// In debug mode, it goes to the loggers as well.
+func (s *service) setOutput(command *exec.Cmd, out io.Writer) {
+	command.Stdout = out
+	command.Stderr = out
+	if s.debug {
+		command.Stdout = io.MultiWriter(out, logWriter{s.stdLog})
+		command.Stderr = io.MultiWriter(out, logWriter{s.errLog})
+	}
+}
+
 type logWriter struct {
 	logger *log.Logger
 }
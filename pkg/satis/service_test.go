@@ -8,9 +8,11 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/reedom/satishub/pkg/satis"
 	"github.com/stretchr/testify/assert"
 )
@@ -54,12 +56,14 @@ func TestService(t *testing.T) {
 		Type: "vcs",
 	}
 
-	ch2 := s.UpdatePackage(pkg)
+	jobID, ch2, _ := s.UpdatePackage(pkg)
+	assert.NotEmpty(t, jobID)
 	select {
 	case <-ctx.Done():
 		assert.Fail(t, "timeout")
 	case result := <-ch:
 		assert.NoError(t, result.Error)
+		assert.Equal(t, jobID, result.JobID)
 	}
 
 	select {
@@ -69,13 +73,27 @@ func TestService(t *testing.T) {
 		assert.NoError(t, result.Error)
 	}
 
+	job, ok := s.Job(jobID)
+	assert.True(t, ok)
+	assert.Equal(t, satis.JobSucceeded, job.State)
+	assert.Equal(t, satis.JobKindPartial, job.Kind)
+	assert.NotNil(t, job.StartedAt)
+	assert.NotNil(t, job.FinishedAt)
+	if assert.NotNil(t, job.ExitCode) {
+		assert.Equal(t, 0, *job.ExitCode)
+	}
+
+	// satis output goes to the job log rather than the loggers
 	assert.Empty(t, string(errBuf.Bytes()))
-	expected := fmt.Sprintf("satis build %v %v %v\n", param.ConfigPath, param.RepoPath, pkg.Name)
-	assert.Equal(t, expected, string(stdBuf.Bytes()))
+	assert.Empty(t, string(stdBuf.Bytes()))
+	output, ok := s.JobLog(jobID)
+	assert.True(t, ok)
+	expected := fmt.Sprintf("build %v %v -- %v\n", param.ConfigPath, param.RepoPath, pkg.Name)
+	assert.Equal(t, expected, string(output))
 
 	// Rebuild
 
-	ch2 = s.Rebuild()
+	rebuildID, ch2, _ := s.Rebuild()
 	select {
 	case <-ctx.Done():
 		assert.Fail(t, "timeout")
@@ -90,8 +108,17 @@ func TestService(t *testing.T) {
 		assert.NoError(t, result.Error)
 	}
 
-	expected += fmt.Sprintf("satis build %v %v\n", param.ConfigPath, param.RepoPath)
-	assert.Equal(t, expected, string(stdBuf.Bytes()))
+	output, ok = s.JobLog(rebuildID)
+	assert.True(t, ok)
+	expected = fmt.Sprintf("build %v %v\n", param.ConfigPath, param.RepoPath)
+	assert.Equal(t, expected, string(output))
+
+	jobs := s.Jobs()
+	if assert.Len(t, jobs, 2) {
+		assert.Equal(t, rebuildID, jobs[0].ID)
+		assert.Equal(t, satis.JobKindRebuild, jobs[0].Kind)
+		assert.Equal(t, jobID, jobs[1].ID)
+	}
 
 	cancel()
 
@@ -154,24 +181,909 @@ func TestUpdatePackageTimeout(t *testing.T) {
 			Type: "vcs",
 		}
 
-		s.UpdatePackage(pkg)
+		jobID, _, _ := s.UpdatePackage(pkg)
 		select {
 		case <-ctx.Done():
 			assert.Fail(t, "timeout")
 		case result := <-ch:
 			assert.Contains(t, result.Error.Error(), "satis command execution timeout")
 		}
+
+		job, _ := s.Job(jobID)
+		assert.Equal(t, satis.JobTimedOut, job.State)
 	})
 }
 
 func TestRebuildTimeout(t *testing.T) {
 	createServer(t, func(ctx context.Context, s satis.Service, ch <-chan satis.ServiceResult, wout, werr io.Writer) {
-		s.Rebuild()
+		jobID, _, _ := s.Rebuild()
 		select {
 		case <-ctx.Done():
 			assert.Fail(t, "timeout")
 		case result := <-ch:
 			assert.Contains(t, result.Error.Error(), "satis command execution timeout")
 		}
+
+		job, _ := s.Job(jobID)
+		assert.Equal(t, satis.JobTimedOut, job.State)
+	})
+}
+
+// startService starts the service with "echo" as the satis executable by default.
+func startService(t *testing.T, param satis.ServiceParam) (satis.Service, <-chan satis.ServiceResult, func()) {
+	config, err := ioutil.TempFile("", "satis-test")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	config.WriteString("{}")
+	config.Close()
+
+	if param.SatisPath == "" {
+		param.SatisPath = "echo"
+	}
+	param.ConfigPath = config.Name()
+	if param.RepoPath == "" {
+		param.RepoPath = "outRepoDir"
+	}
+	param.StdLog = log.New(ioutil.Discard, "", 0)
+	param.ErrLog = log.New(ioutil.Discard, "", 0)
+	s := satis.NewService(param)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := s.Run(ctx)
+	return s, ch, func() {
+		cancel()
+		s.Close()
+		os.Remove(config.Name())
+		os.Remove(config.Name() + ".lock")
+	}
+}
+
+func TestJobHistory(t *testing.T) {
+	s, ch, stop := startService(t, satis.ServiceParam{Timeout: 5 * time.Second, JobHistory: 1})
+	defer stop()
+
+	first, _, _ := s.Rebuild()
+	<-ch
+	second, _, _ := s.Rebuild()
+	<-ch
+
+	_, ok := s.Job(first)
+	assert.False(t, ok)
+	_, ok = s.JobLog(first)
+	assert.False(t, ok)
+	_, ok = s.Job(second)
+	assert.True(t, ok)
+	assert.Len(t, s.Jobs(), 1)
+}
+
+func TestSubscribe(t *testing.T) {
+	s, _, stop := startService(t, satis.ServiceParam{Timeout: 5 * time.Second})
+	defer stop()
+
+	all, _ := s.Subscribe("")
+	defer all.Close()
+
+	jobID, _, _ := s.Rebuild()
+	sub, ok := s.Subscribe(jobID)
+	assert.True(t, ok)
+	defer sub.Close()
+
+	states := []satis.JobState{sub.Job.State}
+	output := string(sub.Output)
+	for !states[len(states)-1].Finished() {
+		select {
+		case ev := <-sub.Events:
+			switch ev.Type {
+			case satis.JobEventState:
+				states = append(states, ev.Job.State)
+			case satis.JobEventOutput:
+				output += string(ev.Output)
+			}
+		case <-time.After(time.Second):
+			assert.Fail(t, "timeout")
+			return
+		}
+	}
+	assert.Equal(t, satis.JobSucceeded, states[len(states)-1])
+	assert.Contains(t, output, "build ")
+
+	select {
+	case ev := <-all.Events:
+		assert.Equal(t, satis.JobQueued, ev.Job.State)
+		assert.Equal(t, jobID, ev.Job.ID)
+	case <-time.After(time.Second):
+		assert.Fail(t, "timeout")
+	}
+
+	_, ok = s.Subscribe("unknown")
+	assert.False(t, ok)
+}
+
+func TestSubscribeSlowReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "satis-bin")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "satis")
+	ioutil.WriteFile(path, []byte("#!/bin/sh\nfor i in $(seq 1 300); do echo $i; sleep 0.002; done\n"), 0755)
+
+	s, ch, stop := startService(t, satis.ServiceParam{SatisPath: path, Timeout: 10 * time.Second})
+	defer stop()
+	jobID, _, _ := s.Rebuild()
+	sub, _ := s.Subscribe(jobID)
+	defer sub.Close()
+	assert.NoError(t, (<-ch).Error)
+
+	// the output fills the buffer, but the finished state still comes last
+	var last satis.JobEvent
+	n := 0
+	for len(sub.Events) != 0 {
+		last = <-sub.Events
+		n++
+	}
+	assert.True(t, 200 < n)
+	if assert.Equal(t, satis.JobEventState, last.Type) {
+		assert.Equal(t, satis.JobSucceeded, last.Job.State)
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	s, ch, stop := startService(t, satis.ServiceParam{Timeout: 5 * time.Second, Debounce: 200 * time.Millisecond})
+	defer stop()
+
+	a := satis.PackageInfo{Name: "test/a", URL: "http://example.com/a", Type: "vcs"}
+	b := satis.PackageInfo{Name: "test/b", URL: "http://example.com/b", Type: "vcs"}
+	id1, ch1, _ := s.UpdatePackage(a)
+	id2, ch2, _ := s.UpdatePackage(b)
+	a.Version = "^1.0"
+	id3, ch3, _ := s.UpdatePackage(a)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case r := <-ch:
+			assert.NoError(t, r.Error)
+		case <-time.After(time.Second):
+			assert.Fail(t, "timeout")
+			return
+		}
+	}
+	for _, c := range []chan satis.ServiceResult{ch1, ch2, ch3} {
+		r := <-c
+		assert.NoError(t, r.Error)
+	}
+
+	expected := fmt.Sprintf("build %v outRepoDir -- test/a test/b\n", s.ConfigPath())
+	for _, id := range []string{id1, id2, id3} {
+		output, _ := s.JobLog(id)
+		assert.Equal(t, expected, string(output))
+	}
+
+	config, err := ioutil.ReadFile(s.ConfigPath())
+	assert.NoError(t, err)
+	assert.Contains(t, string(config), `"test/a": "^1.0"`)
+	assert.Contains(t, string(config), "http://example.com/b")
+}
+
+func TestDebounceThreshold(t *testing.T) {
+	s, ch, stop := startService(t, satis.ServiceParam{
+		Timeout:        5 * time.Second,
+		Debounce:       200 * time.Millisecond,
+		BatchThreshold: 1,
 	})
+	defer stop()
+
+	id, _, _ := s.UpdatePackage(satis.PackageInfo{Name: "test/a", URL: "http://example.com/a", Type: "vcs"})
+	s.UpdatePackage(satis.PackageInfo{Name: "test/b", URL: "http://example.com/b", Type: "vcs"})
+	<-ch
+	<-ch
+
+	output, _ := s.JobLog(id)
+	assert.Equal(t, fmt.Sprintf("build %v outRepoDir\n", s.ConfigPath()), string(output))
+}
+
+func TestDebounceRebuild(t *testing.T) {
+	s, ch, stop := startService(t, satis.ServiceParam{Timeout: 5 * time.Second, Debounce: time.Second})
+	defer stop()
+
+	_, ch1, _ := s.UpdatePackage(satis.PackageInfo{Name: "test/a", URL: "http://example.com/a", Type: "vcs"})
+	time.Sleep(50 * time.Millisecond)
+
+	// a rebuild during the debounce window runs at once and discards the batch
+	_, ch2, _ := s.Rebuild()
+	select {
+	case r := <-ch2:
+		assert.NoError(t, r.Error)
+	case <-time.After(500 * time.Millisecond):
+		assert.Fail(t, "rebuild waited for the debounce window")
+		return
+	}
+	assert.Error(t, (<-ch1).Error)
+	assert.NoError(t, (<-ch).Error)
+
+	// the rebuild takes over the config update of the discarded request
+	config, err := ioutil.ReadFile(s.ConfigPath())
+	assert.NoError(t, err)
+	assert.Contains(t, string(config), "http://example.com/a")
+}
+
+// slowSatis creates a fake satis executable which takes a while.
+func slowSatis(t *testing.T) string {
+	f, err := ioutil.TempFile("", "satis-slow")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	f.WriteString("#!/bin/sh\nexec sleep 10\n")
+	f.Close()
+	os.Chmod(f.Name(), 0755)
+	return f.Name()
+}
+
+func waitState(t *testing.T, s satis.Service, jobID string, state satis.JobState) {
+	for i := 0; i < 100; i++ {
+		if job, _ := s.Job(jobID); job.State == state {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Fail(t, "job state not changed", "expected %v", state)
+}
+
+func TestCancel(t *testing.T) {
+	satisPath := slowSatis(t)
+	defer os.Remove(satisPath)
+
+	s, ch, stop := startService(t, satis.ServiceParam{SatisPath: satisPath, Timeout: 20 * time.Second})
+	defer stop()
+	go func() {
+		for range ch {
+		}
+	}()
+
+	running, ch1, _ := s.Rebuild()
+	waitState(t, s, running, satis.JobRunning)
+	queued, ch2, _ := s.UpdatePackage(satis.PackageInfo{Name: "test/a", URL: "http://example.com/a", Type: "vcs"})
+
+	assert.NoError(t, s.Cancel(queued))
+	assert.NoError(t, s.Cancel(running))
+	assert.Equal(t, satis.ErrJobNotFound, s.Cancel("unknown"))
+
+	for _, c := range []chan satis.ServiceResult{ch1, ch2} {
+		select {
+		case r := <-c:
+			assert.Equal(t, satis.ErrJobCancelled, errors.Cause(r.Error))
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "timeout")
+		}
+	}
+
+	job, _ := s.Job(running)
+	assert.Equal(t, satis.JobCancelled, job.State)
+	job, _ = s.Job(queued)
+	assert.Equal(t, satis.JobCancelled, job.State)
+	assert.Nil(t, job.StartedAt)
+	assert.Equal(t, satis.ErrJobFinished, s.Cancel(running))
+}
+
+func TestCancelBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "satis-bin")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	// satis hangs on building test/a
+	path := filepath.Join(dir, "satis")
+	ioutil.WriteFile(path, []byte("#!/bin/sh\ncase \"$*\" in *test/a*) exec sleep 10;; esac\necho \"$@\"\n"), 0755)
+
+	s, ch, stop := startService(t, satis.ServiceParam{SatisPath: path, Timeout: 20 * time.Second, Debounce: 100 * time.Millisecond})
+	defer stop()
+	go func() {
+		for range ch {
+		}
+	}()
+
+	a, ch1, _ := s.UpdatePackage(satis.PackageInfo{Name: "test/a", URL: "http://example.com/a", Type: "vcs"})
+	b, ch2, _ := s.UpdatePackage(satis.PackageInfo{Name: "test/b", URL: "http://example.com/b", Type: "vcs"})
+	waitState(t, s, b, satis.JobRunning)
+
+	// the others of the batch are built again without the cancelled job
+	assert.NoError(t, s.Cancel(a))
+	for _, c := range []struct {
+		ch  chan satis.ServiceResult
+		err error
+	}{{ch1, satis.ErrJobCancelled}, {ch2, nil}} {
+		select {
+		case r := <-c.ch:
+			assert.Equal(t, c.err, errors.Cause(r.Error))
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "timeout")
+		}
+	}
+
+	job, _ := s.Job(a)
+	assert.Equal(t, satis.JobCancelled, job.State)
+	job, _ = s.Job(b)
+	assert.Equal(t, satis.JobSucceeded, job.State)
+	output, _ := s.JobLog(b)
+	assert.Equal(t, fmt.Sprintf("build %v outRepoDir -- test/b\n", s.ConfigPath()), string(output))
+}
+
+func TestSupersede(t *testing.T) {
+	satisPath := slowSatis(t)
+	defer os.Remove(satisPath)
+
+	s, ch, stop := startService(t, satis.ServiceParam{SatisPath: satisPath, Timeout: 20 * time.Second, Supersede: true})
+	defer stop()
+	go func() {
+		for range ch {
+		}
+	}()
+
+	partial, ch1, _ := s.UpdatePackage(satis.PackageInfo{Name: "test/a", URL: "http://example.com/a", Type: "vcs"})
+	waitState(t, s, partial, satis.JobRunning)
+
+	rebuild, ch2, _ := s.Rebuild()
+	select {
+	case r := <-ch1:
+		assert.Equal(t, satis.ErrJobCancelled, errors.Cause(r.Error))
+		assert.Contains(t, r.Error.Error(), "superseded by job "+rebuild)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "timeout")
+	}
+
+	job, _ := s.Job(partial)
+	assert.Equal(t, satis.JobCancelled, job.State)
+	waitState(t, s, rebuild, satis.JobRunning)
+	assert.NoError(t, s.Cancel(rebuild))
+	<-ch2
+}
+
+func TestQueueFull(t *testing.T) {
+	satisPath := slowSatis(t)
+	defer os.Remove(satisPath)
+
+	s, ch, stop := startService(t, satis.ServiceParam{SatisPath: satisPath, Timeout: 20 * time.Second, QueueSize: 1})
+	defer stop()
+	go func() {
+		for range ch {
+		}
+	}()
+
+	running, _, err := s.Rebuild()
+	assert.NoError(t, err)
+	waitState(t, s, running, satis.JobRunning)
+
+	queued, _, err := s.UpdatePackage(satis.PackageInfo{Name: "test/a", URL: "http://example.com/a", Type: "vcs"})
+	assert.NoError(t, err)
+	_, _, err = s.UpdatePackage(satis.PackageInfo{Name: "test/b", URL: "http://example.com/b", Type: "vcs"})
+	assert.Equal(t, satis.ErrQueueFull, err)
+	assert.Len(t, s.Jobs(), 3)
+
+	assert.NoError(t, s.Cancel(queued))
+	assert.NoError(t, s.Cancel(running))
+}
+
+func TestQueueJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "satis-queue")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	config := filepath.Join(dir, "satis.json")
+	ioutil.WriteFile(config, []byte("{}"), 0644)
+
+	param := satis.ServiceParam{
+		SatisPath:  "echo",
+		ConfigPath: config,
+		RepoPath:   "outRepoDir",
+		Timeout:    5 * time.Second,
+		QueuePath:  filepath.Join(dir, "queue.jsonl"),
+		StdLog:     log.New(ioutil.Discard, "", 0),
+		ErrLog:     log.New(ioutil.Discard, "", 0),
+	}
+
+	// queue requests without running the service
+	s := satis.NewService(param)
+	rebuild, _, _ := s.Rebuild()
+	partial, _, _ := s.UpdatePackage(satis.PackageInfo{Name: "test/a", URL: "http://example.com/a", Type: "vcs"})
+	cancelled, _, _ := s.Rebuild()
+	assert.NoError(t, s.Cancel(cancelled))
+	s.Close()
+
+	// the next process picks them up
+	s = satis.NewService(param)
+	jobs := s.Jobs()
+	if assert.Len(t, jobs, 2) {
+		assert.Equal(t, partial, jobs[0].ID)
+		assert.Equal(t, satis.JobQueued, jobs[0].State)
+		assert.Equal(t, "test/a", jobs[0].Package.Name)
+		assert.Equal(t, rebuild, jobs[1].ID)
+		assert.Equal(t, satis.JobKindRebuild, jobs[1].Kind)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := s.Run(ctx)
+	exited := make(chan struct{})
+	go func() {
+		for range ch {
+		}
+		close(exited)
+	}()
+	// the rebuild may discard the partial; either way both of them finish
+	waitState(t, s, rebuild, satis.JobSucceeded)
+	job, _ := s.Job(partial)
+	assert.True(t, job.State.Finished())
+	cancel()
+	<-exited
+	s.Close()
+
+	// nothing left after the jobs finished
+	s = satis.NewService(param)
+	assert.Len(t, s.Jobs(), 0)
+	s.Close()
+}
+
+func TestQueueJournalCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "satis-queue")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	defer func(n int) { *satis.JournalCompactRecords = n }(*satis.JournalCompactRecords)
+	*satis.JournalCompactRecords = 10
+
+	// the records of the finished jobs do not pile up
+	path := filepath.Join(dir, "queue.jsonl")
+	pending, err := satis.JournalRecords(path, []satis.Job{{ID: "a"}, {ID: "b"}}, 100)
+	assert.NoError(t, err)
+	if assert.Len(t, pending, 2) {
+		assert.Equal(t, "a", pending[0].ID)
+		assert.Equal(t, "b", pending[1].ID)
+	}
+	data, _ := ioutil.ReadFile(path)
+	assert.True(t, bytes.Count(data, []byte("\n")) <= 12)
+}
+
+// flakySatis returns a satis script which fails the first n runs.
+func flakySatis(t *testing.T, n int) string {
+	dir, err := ioutil.TempDir("", "satis-flaky")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	path := filepath.Join(dir, "satis")
+	script := fmt.Sprintf("#!/bin/sh\necho run >> %s/runs\n[ $(wc -l < %s/runs) -gt %d ] && exit 0\necho transient error\nexit 1\n", dir, dir, n)
+	ioutil.WriteFile(path, []byte(script), 0755)
+	return path
+}
+
+func TestRetry(t *testing.T) {
+	satisPath := flakySatis(t, 2)
+	defer os.RemoveAll(filepath.Dir(satisPath))
+
+	retry := satis.RetryPolicy{MaxAttempts: 3, BackoffBase: 10 * time.Millisecond, Jitter: 0.5}
+	s, ch, stop := startService(t, satis.ServiceParam{SatisPath: satisPath, Timeout: 5 * time.Second, Retry: retry})
+	defer stop()
+
+	jobID, _, _ := s.Rebuild()
+	r := <-ch
+	assert.NoError(t, r.Error)
+
+	job, _ := s.Job(jobID)
+	assert.Equal(t, satis.JobSucceeded, job.State)
+	assert.Equal(t, 3, job.Attempts)
+	out, _ := s.JobLog(jobID)
+	assert.Contains(t, string(out), "attempt 1/3 failed: exit status 1")
+	assert.Contains(t, string(out), "attempt 2/3 failed: exit status 1")
+}
+
+func TestRetryExhausted(t *testing.T) {
+	satisPath := flakySatis(t, 5)
+	defer os.RemoveAll(filepath.Dir(satisPath))
+
+	retry := satis.RetryPolicy{MaxAttempts: 2, BackoffBase: 10 * time.Millisecond}
+	s, ch, stop := startService(t, satis.ServiceParam{SatisPath: satisPath, Timeout: 5 * time.Second, Retry: retry})
+	defer stop()
+
+	jobID, _, _ := s.UpdatePackage(satis.PackageInfo{Name: "test/a", URL: "http://example.com/a", Type: "vcs"})
+	r := <-ch
+	assert.Error(t, r.Error)
+
+	job, _ := s.Job(jobID)
+	assert.Equal(t, satis.JobFailed, job.State)
+	assert.Equal(t, 2, job.Attempts)
+}
+
+func TestRetryTimeout(t *testing.T) {
+	satisPath := slowSatis(t)
+	defer os.Remove(satisPath)
+
+	for _, retryTimeout := range []bool{false, true} {
+		retry := satis.RetryPolicy{MaxAttempts: 2, BackoffBase: 10 * time.Millisecond, RetryTimeout: retryTimeout}
+		s, ch, stop := startService(t, satis.ServiceParam{SatisPath: satisPath, Timeout: 100 * time.Millisecond, Retry: retry})
+
+		jobID, _, _ := s.Rebuild()
+		r := <-ch
+		assert.Contains(t, r.Error.Error(), "satis command execution timeout")
+
+		job, _ := s.Job(jobID)
+		assert.Equal(t, satis.JobTimedOut, job.State)
+		if retryTimeout {
+			assert.Equal(t, 2, job.Attempts)
+		} else {
+			assert.Equal(t, 1, job.Attempts)
+		}
+		stop()
+	}
+}
+
+func TestWorkers(t *testing.T) {
+	// the native builder runs the partial builds in parallel, with a slow git
+	gitPath := slowSatis(t)
+	defer os.Remove(gitPath)
+	mirrors, err := ioutil.TempDir("", "satis-mirrors")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(mirrors)
+
+	s, ch, stop := startService(t, satis.ServiceParam{
+		Timeout:    20 * time.Second,
+		Workers:    2,
+		Builder:    satis.BuilderNative,
+		GitPath:    gitPath,
+		MirrorPath: mirrors,
+	})
+	defer stop()
+	go func() {
+		for range ch {
+		}
+	}()
+
+	a := satis.PackageInfo{Name: "test/a", URL: "http://example.com/a", Type: "vcs"}
+	b := satis.PackageInfo{Name: "test/b", URL: "http://example.com/b", Type: "vcs"}
+
+	// different packages build at the same time
+	a1, _, _ := s.UpdatePackage(a)
+	waitState(t, s, a1, satis.JobRunning)
+	b1, _, _ := s.UpdatePackage(b)
+	waitState(t, s, b1, satis.JobRunning)
+
+	// the same package waits for the running build
+	a2, _, _ := s.UpdatePackage(a)
+	time.Sleep(50 * time.Millisecond)
+	job, _ := s.Job(a2)
+	assert.Equal(t, satis.JobQueued, job.State)
+
+	// a full rebuild waits for all of the builds
+	rebuild, _, _ := s.Rebuild()
+	time.Sleep(50 * time.Millisecond)
+	job, _ = s.Job(rebuild)
+	assert.Equal(t, satis.JobQueued, job.State)
+	job, _ = s.Job(a2)
+	assert.Equal(t, satis.JobDiscarded, job.State)
+
+	assert.NoError(t, s.Cancel(a1))
+	waitState(t, s, a1, satis.JobCancelled)
+	job, _ = s.Job(rebuild)
+	assert.Equal(t, satis.JobQueued, job.State)
+
+	assert.NoError(t, s.Cancel(b1))
+	waitState(t, s, rebuild, satis.JobRunning)
+	assert.NoError(t, s.Cancel(rebuild))
+	waitState(t, s, rebuild, satis.JobCancelled)
+}
+
+func TestWorkersSatis(t *testing.T) {
+	satisPath := slowSatis(t)
+	defer os.Remove(satisPath)
+
+	s, ch, stop := startService(t, satis.ServiceParam{SatisPath: satisPath, Timeout: 20 * time.Second, Workers: 2})
+	defer stop()
+	go func() {
+		for range ch {
+		}
+	}()
+
+	// satis builds run one at a time, since they write the same files
+	a, _, _ := s.UpdatePackage(satis.PackageInfo{Name: "test/a", URL: "http://example.com/a", Type: "vcs"})
+	waitState(t, s, a, satis.JobRunning)
+	b, _, _ := s.UpdatePackage(satis.PackageInfo{Name: "test/b", URL: "http://example.com/b", Type: "vcs"})
+	time.Sleep(50 * time.Millisecond)
+	job, _ := s.Job(b)
+	assert.Equal(t, satis.JobQueued, job.State)
+
+	assert.NoError(t, s.Cancel(a))
+	waitState(t, s, b, satis.JobRunning)
+	assert.NoError(t, s.Cancel(b))
+	waitState(t, s, b, satis.JobCancelled)
+}
+
+func TestWorkersConfigUpdate(t *testing.T) {
+	s, ch, stop := startService(t, satis.ServiceParam{Timeout: 5 * time.Second, Workers: 4})
+	defer stop()
+
+	const n = 12
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("test/p%d", i)
+		_, _, err := s.UpdatePackage(satis.PackageInfo{Name: name, URL: "http://example.com/" + name, Type: "vcs"})
+		assert.NoError(t, err)
+	}
+	for i := 0; i < n; i++ {
+		r := <-ch
+		assert.NoError(t, r.Error)
+	}
+
+	data, err := ioutil.ReadFile(s.ConfigPath())
+	assert.NoError(t, err)
+	for i := 0; i < n; i++ {
+		assert.Contains(t, string(data), fmt.Sprintf("http://example.com/test/p%d", i))
+	}
+}
+
+func TestRemovePackage(t *testing.T) {
+	repo, err := ioutil.TempDir("", "satis-repo")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(repo)
+	for _, path := range []string{"p2/test/a.json", "p2/test/a~dev.json", "p2/test/b.json", "dist/test/a/a-1.0.zip"} {
+		path = filepath.Join(repo, filepath.FromSlash(path))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte("{}"), 0644)
+	}
+
+	s, ch, stop := startService(t, satis.ServiceParam{RepoPath: repo, Timeout: 5 * time.Second})
+	defer stop()
+	assert.NoError(t, satis.UpdateConfig(s.ConfigPath(), []satis.PackageInfo{
+		{Name: "test/a", URL: "http://example.com/test/a.git", Type: "vcs", Version: "*"},
+		{Name: "test/b", URL: "http://example.com/test/b.git", Type: "vcs"},
+	}))
+
+	_, _, err = s.RemovePackage(satis.PackageInfo{Name: "../a"})
+	assert.Equal(t, satis.ErrInvalidPackageName, err)
+	_, _, err = s.UpdatePackage(satis.PackageInfo{Name: "--no-interaction", URL: "http://example.com/a"})
+	assert.Equal(t, satis.ErrInvalidPackageName, err)
+
+	jobID, _, err := s.RemovePackage(satis.PackageInfo{Name: "test/a"})
+	assert.NoError(t, err)
+	r := <-ch
+	assert.NoError(t, r.Error)
+	job, _ := s.Job(jobID)
+	assert.Equal(t, satis.JobKindRemove, job.Kind)
+	assert.Equal(t, satis.JobSucceeded, job.State)
+
+	config, _ := ioutil.ReadFile(s.ConfigPath())
+	assert.NotContains(t, string(config), "test/a")
+	assert.Contains(t, string(config), "http://example.com/test/b.git")
+	out, _ := s.JobLog(jobID)
+	assert.Equal(t, "build "+s.ConfigPath()+" "+repo+"\n", string(out))
+
+	// the files of the package are purged
+	for _, path := range []string{"p2/test/a.json", "p2/test/a~dev.json", "dist/test/a"} {
+		_, err := os.Stat(filepath.Join(repo, filepath.FromSlash(path)))
+		assert.True(t, os.IsNotExist(err), path)
+	}
+	_, err = os.Stat(filepath.Join(repo, "p2/test/b.json"))
+	assert.NoError(t, err)
+}
+
+func TestConfigHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "satis-history")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	s, ch, stop := startService(t, satis.ServiceParam{HistoryPath: dir, Timeout: 5 * time.Second})
+	defer stop()
+
+	jobID, _, err := s.UpdatePackage(satis.PackageInfo{Name: "test/a", URL: "http://example.com/test/a.git", Type: "vcs", Source: "/webhook/gitlab"})
+	assert.NoError(t, err)
+	assert.NoError(t, (<-ch).Error)
+
+	// an edit by hand is recorded before the next change
+	assert.NoError(t, ioutil.WriteFile(s.ConfigPath(), []byte(`{"name": "test", "homepage": "http://example.com"}`+"\n"), 0644))
+	assert.NoError(t, s.EditConfig("PATCH /api/v1/config", func(configPath string) error {
+		return satis.PatchConfig(configPath, map[string]interface{}{"description": "test"})
+	}))
+
+	revs, err := s.History().Revisions()
+	assert.NoError(t, err)
+	if assert.Len(t, revs, 4) {
+		assert.Equal(t, "PATCH /api/v1/config", revs[0].Source)
+		assert.Equal(t, "external", revs[1].Source)
+		assert.Equal(t, []string{jobID}, revs[2].JobIDs)
+		assert.Equal(t, "/webhook/gitlab", revs[2].Source)
+		assert.Equal(t, "startup", revs[3].Source)
+	}
+
+	diff, err := s.History().Diff(1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, `--- revision 1
++++ revision 2
+@@ -1 +1,8 @@
+-{}
++{
++  "repositories": [
++    {
++      "type": "vcs",
++      "url": "http://example.com/test/a.git"
++    }
++  ]
++}
+`, diff)
+
+	_, _, err = s.Rollback(10)
+	assert.Equal(t, satis.ErrRevisionNotFound, err)
+
+	// revision 2 lacks "name" and "homepage" which the config has now
+	_, _, err = s.Rollback(2)
+	assert.NoError(t, err)
+	r := <-ch
+	if _, ok := errors.Cause(r.Error).(*satis.ValidationError); !ok {
+		t.Errorf("expected a validation error, got %v", r.Error)
+	}
+
+	jobID, _, err = s.Rollback(3)
+	assert.NoError(t, err)
+	r = <-ch
+	assert.NoError(t, r.Error)
+	job, _ := s.Job(jobID)
+	assert.Equal(t, satis.JobKindRollback, job.Kind)
+	assert.Equal(t, 3, job.Revision)
+	out, _ := s.JobLog(jobID)
+	assert.Equal(t, "build "+s.ConfigPath()+" outRepoDir\n", string(out))
+
+	_, want, _ := s.History().Revision(3)
+	config, _ := ioutil.ReadFile(s.ConfigPath())
+	assert.Equal(t, string(want), string(config))
+	revs, _ = s.History().Revisions()
+	assert.Equal(t, 5, revs[0].Number)
+	assert.Equal(t, "rollback to revision 3", revs[0].Source)
+	assert.Equal(t, []string{jobID}, revs[0].JobIDs)
+	diff, _ = s.History().Diff(3, 5)
+	assert.Empty(t, diff)
+}
+
+// generationSatis returns a fake satis which makes an archive in archiveDir
+// once and adds a line to packages.json on each build. It fails while "fail"
+// exists next to it.
+func generationSatis(t *testing.T, archiveDir string) string {
+	dir, err := ioutil.TempDir("", "satis-bin")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	path := filepath.Join(dir, "satis")
+	script := fmt.Sprintf("#!/bin/sh\n[ -f %s/fail ] && exit 1\nmkdir -p \"$3/%[2]s\"\n[ -f \"$3/%[2]s/a.zip\" ] || echo zip > \"$3/%[2]s/a.zip\"\necho build >> \"$3/packages.json\"\n", dir, archiveDir)
+	ioutil.WriteFile(path, []byte(script), 0755)
+	return path
+}
+
+func TestGenerations(t *testing.T) {
+	satisPath := generationSatis(t, "dist")
+	defer os.RemoveAll(filepath.Dir(satisPath))
+	dir, err := ioutil.TempDir("", "satis-repo")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	repo := filepath.Join(dir, "repo")
+	os.Mkdir(repo, 0755)
+	ioutil.WriteFile(filepath.Join(repo, "index.html"), []byte("index"), 0644)
+
+	s, ch, stop := startService(t, satis.ServiceParam{SatisPath: satisPath, RepoPath: repo, Timeout: 5 * time.Second, Generations: 2})
+	defer stop()
+
+	// the output directory becomes the first generation
+	gens, err := s.Generations()
+	assert.NoError(t, err)
+	if assert.Len(t, gens, 1) {
+		assert.True(t, gens[0].Current)
+	}
+	first := gens[0].ID
+	info, _ := os.Lstat(repo)
+	assert.True(t, info.Mode()&os.ModeSymlink != 0)
+
+	for i := 0; i < 3; i++ {
+		_, _, err = s.Rebuild()
+		assert.NoError(t, err)
+		assert.NoError(t, (<-ch).Error)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(repo, "packages.json"))
+	assert.Equal(t, "build\nbuild\nbuild\n", string(data))
+	data, _ = ioutil.ReadFile(filepath.Join(repo, "index.html"))
+	assert.Equal(t, "index", string(data))
+
+	// the older generations beyond the limit are removed
+	gens, _ = s.Generations()
+	if !assert.Len(t, gens, 2) {
+		t.FailNow()
+	}
+	assert.True(t, gens[0].Current)
+	assert.False(t, gens[1].Current)
+	assert.NotEqual(t, first, gens[1].ID)
+	latest, _ := os.Stat(filepath.Join(dir, "repo.generations", gens[0].ID, "dist/a.zip"))
+	previous, _ := os.Stat(filepath.Join(dir, "repo.generations", gens[1].ID, "dist/a.zip"))
+	assert.True(t, os.SameFile(latest, previous))
+
+	// a failed build leaves the current generation
+	ioutil.WriteFile(filepath.Join(filepath.Dir(satisPath), "fail"), nil, 0644)
+	s.Rebuild()
+	assert.Error(t, (<-ch).Error)
+	after, _ := s.Generations()
+	assert.Equal(t, gens, after)
+	names, _ := filepath.Glob(filepath.Join(dir, "repo.generations", "*"))
+	assert.Len(t, names, 2)
+
+	_, _, err = s.SwitchGeneration("../repo")
+	assert.Equal(t, satis.ErrGenerationNotFound, err)
+
+	jobID, _, err := s.SwitchGeneration(gens[1].ID)
+	assert.NoError(t, err)
+	assert.NoError(t, (<-ch).Error)
+	job, _ := s.Job(jobID)
+	assert.Equal(t, satis.JobKindSwitch, job.Kind)
+	assert.Equal(t, gens[1].ID, job.Generation)
+	data, _ = ioutil.ReadFile(filepath.Join(repo, "packages.json"))
+	assert.Equal(t, "build\nbuild\n", string(data))
+	gens, _ = s.Generations()
+	assert.False(t, gens[0].Current)
+	assert.True(t, gens[1].Current)
+}
+
+func TestGenerationsArchiveDirectory(t *testing.T) {
+	satisPath := generationSatis(t, "archives")
+	defer os.RemoveAll(filepath.Dir(satisPath))
+	dir, err := ioutil.TempDir("", "satis-repo")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	repo := filepath.Join(dir, "repo")
+	os.Mkdir(repo, 0755)
+
+	s, ch, stop := startService(t, satis.ServiceParam{SatisPath: satisPath, RepoPath: repo, Timeout: 5 * time.Second, Generations: 2})
+	defer stop()
+	ioutil.WriteFile(s.ConfigPath(), []byte(`{"archive": {"directory": "archives"}}`), 0644)
+
+	// the archives in the configured directory are linked, not copied
+	for i := 0; i < 2; i++ {
+		s.Rebuild()
+		assert.NoError(t, (<-ch).Error)
+	}
+	gens, _ := s.Generations()
+	if !assert.Len(t, gens, 2) {
+		t.FailNow()
+	}
+	latest, _ := os.Stat(filepath.Join(dir, "repo.generations", gens[0].ID, "archives/a.zip"))
+	previous, _ := os.Stat(filepath.Join(dir, "repo.generations", gens[1].ID, "archives/a.zip"))
+	assert.True(t, os.SameFile(latest, previous))
+}
+
+func TestArchivePurge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "satis-bin")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	satisPath := filepath.Join(dir, "satis")
+	logPath := filepath.Join(dir, "log")
+	ioutil.WriteFile(satisPath, []byte("#!/bin/sh\necho \"$@\" >> "+logPath+"\n"), 0755)
+
+	s, ch, stop := startService(t, satis.ServiceParam{SatisPath: satisPath, RepoPath: "repo", Timeout: 5 * time.Second})
+	defer stop()
+	s.Rebuild()
+	assert.NoError(t, (<-ch).Error)
+
+	// satis purges the archives after a full rebuild only
+	ioutil.WriteFile(s.ConfigPath(), []byte(`{"archive": {"directory": "dist"}}`), 0644)
+	s.Rebuild()
+	assert.NoError(t, (<-ch).Error)
+	s.UpdatePackage(satis.PackageInfo{Name: "test/a"})
+	assert.NoError(t, (<-ch).Error)
+
+	data, _ := ioutil.ReadFile(logPath)
+	config := s.ConfigPath()
+	assert.Equal(t, "build "+config+" repo\nbuild "+config+" repo\npurge "+config+" repo\nbuild "+config+" repo -- test/a\n", string(data))
 }
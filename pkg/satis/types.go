@@ -6,10 +6,13 @@ type PackageInfo struct {
 	Version string `json:"version,omitempty"`
 	URL     string `json:"url,omitempty"`
 	Type    string `json:"type,omitempty"`
+	// Source tells where the request came from, such as the webhook path.
+	Source string `json:"source,omitempty"`
 }
 
 // ServiceResult represents a result of Service tasks.
 type ServiceResult struct {
+	JobID string
 	Error error
 }
 
@@ -0,0 +1,350 @@
+package satis
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+// ConfigError is a problem found in the satis config.
+type ConfigError struct {
+	// Pointer is the JSON pointer (RFC 6901) to the offending value.
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+func (e ConfigError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pointer, e.Message)
+}
+
+// ValidationError is returned for a satis config edit which would make the
+// config invalid.
+type ValidationError struct {
+	Errors []ConfigError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, ce := range e.Errors {
+		msgs[i] = ce.Error()
+	}
+	return "invalid satis config: " + strings.Join(msgs, "; ")
+}
+
+// jsonPointer builds a JSON pointer from object keys (string) and array
+// indexes (int).
+func jsonPointer(path ...interface{}) string {
+	var b bytes.Buffer
+	for _, key := range path {
+		b.WriteByte('/')
+		switch key := key.(type) {
+		case string:
+			key = strings.Replace(key, "~", "~0", -1)
+			b.WriteString(strings.Replace(key, "/", "~1", -1))
+		default:
+			fmt.Fprint(&b, key)
+		}
+	}
+	return b.String()
+}
+
+// ValidateConfig validates the satis config text against the satis config
+// schema and the Composer repository types. It returns *ValidationError
+// listing the problems found.
+func ValidateConfig(data []byte) error {
+	var config interface{}
+	if err := jsoniter.Unmarshal(data, &config); err != nil {
+		return errors.Errorf("satis config file contains invalid JSON content: %s", err.Error())
+	}
+	obj, ok := config.(map[string]interface{})
+	if !ok {
+		return &ValidationError{Errors: []ConfigError{{Pointer: "", Message: "must be an object"}}}
+	}
+	if errs := validateConfig(obj); 0 < len(errs) {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// ValidateConfigFile validates the satis config file. See ValidateConfig.
+func ValidateConfigFile(configPath string) error {
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return errors.Errorf("failed to open satis config file: %s", err.Error())
+	}
+	return ValidateConfig(data)
+}
+
+// vcsRepositoryTypes are the Composer repository types of version control
+// systems, which read the repository at "url".
+var vcsRepositoryTypes = []string{
+	"vcs", "git", "github", "gitlab", "bitbucket", "git-bitbucket",
+	"hg", "hg-bitbucket", "fossil", "svn", "perforce",
+}
+
+// stabilities are the values of "minimum-stability".
+var stabilities = []string{"stable", "RC", "beta", "alpha", "dev"}
+
+// configValidator collects the problems of a decoded satis config.
+type configValidator struct {
+	errs []ConfigError
+}
+
+func (v *configValidator) errorf(path []interface{}, format string, args ...interface{}) {
+	v.errs = append(v.errs, ConfigError{Pointer: jsonPointer(path...), Message: fmt.Sprintf(format, args...)})
+}
+
+// validateConfig checks the fields satis reads, following its config
+// schema. Unknown fields are left to satis.
+func validateConfig(config map[string]interface{}) []ConfigError {
+	v := &configValidator{}
+	for _, key := range []string{"name", "homepage"} {
+		if _, ok := config[key]; !ok {
+			v.errorf([]interface{}{key}, "is required")
+		}
+	}
+	for _, key := range []string{"name", "homepage", "description", "output-dir", "include-filename", "twig-template", "notify-batch"} {
+		v.optionalString(config, key)
+	}
+	for _, key := range []string{"require-all", "require-dependencies", "require-dev-dependencies", "output-html", "providers", "only-best-candidates"} {
+		v.optionalBool(config, key)
+	}
+	if value, ok := config["minimum-stability"]; ok && !contains(stabilities, value) {
+		v.errorf([]interface{}{"minimum-stability"}, "must be one of %s", strings.Join(stabilities, ", "))
+	}
+	if value, ok := config["config"]; ok {
+		if _, ok := value.(map[string]interface{}); !ok {
+			v.errorf([]interface{}{"config"}, "must be an object")
+		}
+	}
+
+	if value, ok := config["repositories"]; ok {
+		repos, ok := value.([]interface{})
+		if !ok {
+			v.errorf([]interface{}{"repositories"}, "must be an array")
+		}
+		for i, repo := range repos {
+			v.repository([]interface{}{"repositories", i}, repo)
+		}
+	}
+
+	for _, key := range []string{"require", "blacklist"} {
+		if value, ok := config[key]; ok {
+			v.constraints([]interface{}{key}, value)
+		}
+	}
+	if value, ok := config["abandoned"]; ok {
+		v.abandoned([]interface{}{"abandoned"}, value)
+	}
+	if value, ok := config["archive"]; ok {
+		v.archive([]interface{}{"archive"}, value)
+	}
+	return v.errs
+}
+
+func (v *configValidator) repository(p []interface{}, value interface{}) {
+	repo, ok := value.(map[string]interface{})
+	if !ok {
+		v.errorf(p, "must be an object")
+		return
+	}
+	// {"packagist.org": false} disables a default repository
+	if _, ok := repo["type"]; !ok && len(repo) == 1 {
+		for _, value := range repo {
+			if value == false {
+				return
+			}
+		}
+	}
+
+	typ, ok := repo["type"].(string)
+	switch {
+	case !ok || typ == "":
+		v.errorf(append(p, "type"), "must be a non-empty string")
+		return
+	case typ == "package":
+		v.inlinePackages(append(p, "package"), repo["package"])
+	case typ == "composer", typ == "path", typ == "artifact", contains(vcsRepositoryTypes, typ):
+		if url, ok := repo["url"].(string); !ok || url == "" {
+			v.errorf(append(p, "url"), "must be a non-empty string")
+		} else if strings.HasPrefix(url, "-") {
+			// git would take it for an option
+			v.errorf(append(p, "url"), "must not start with \"-\"")
+		}
+	default:
+		v.errorf(append(p, "type"), "unknown repository type %q", typ)
+	}
+	if options, ok := repo["options"]; ok {
+		if _, ok := options.(map[string]interface{}); !ok {
+			v.errorf(append(p, "options"), "must be an object")
+		}
+	}
+}
+
+// inlinePackages checks "package" of a "package" repository, which is a
+// package definition or an array of them.
+func (v *configValidator) inlinePackages(p []interface{}, value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		v.inlinePackage(p, value)
+	case []interface{}:
+		for i, pkg := range value {
+			if pkg, ok := pkg.(map[string]interface{}); ok {
+				v.inlinePackage(append(p, i), pkg)
+			} else {
+				v.errorf(append(p, i), "must be an object")
+			}
+		}
+	default:
+		v.errorf(p, "must be an object or an array")
+	}
+}
+
+func (v *configValidator) inlinePackage(p []interface{}, pkg map[string]interface{}) {
+	if name, ok := pkg["name"].(string); !ok || !ValidPackageName(strings.ToLower(name)) {
+		v.errorf(append(p, "name"), "must be a package name")
+	}
+	if version, ok := pkg["version"].(string); !ok || version == "" {
+		v.errorf(append(p, "version"), "must be a non-empty string")
+	}
+	for _, key := range []string{"dist", "source"} {
+		value, ok := pkg[key]
+		if !ok {
+			continue
+		}
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			v.errorf(append(p, key), "must be an object")
+			continue
+		}
+		for _, field := range []string{"type", "url"} {
+			if s, ok := obj[field].(string); !ok || s == "" {
+				v.errorf(append(p, key, field), "must be a non-empty string")
+			}
+		}
+	}
+}
+
+// constraints checks an object of package names and version constraints.
+func (v *configValidator) constraints(p []interface{}, value interface{}) {
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		v.errorf(p, "must be an object")
+		return
+	}
+	for _, name := range sortedKeys(obj) {
+		if !ValidPackageName(strings.ToLower(name)) {
+			v.errorf(append(p, name), "invalid package name")
+		} else if constraint, ok := obj[name].(string); !ok || constraint == "" {
+			v.errorf(append(p, name), "must be a version constraint string")
+		}
+	}
+}
+
+// abandoned checks an object of package names and their replacements, or
+// true for none.
+func (v *configValidator) abandoned(p []interface{}, value interface{}) {
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		v.errorf(p, "must be an object")
+		return
+	}
+	for _, name := range sortedKeys(obj) {
+		switch obj[name].(type) {
+		case string, bool:
+		default:
+			v.errorf(append(p, name), "must be a package name or a boolean")
+		}
+	}
+}
+
+func (v *configValidator) archive(p []interface{}, value interface{}) {
+	archive, ok := value.(map[string]interface{})
+	if !ok {
+		v.errorf(p, "must be an object")
+		return
+	}
+
+	if dir, ok := archive["directory"].(string); !ok || dir == "" {
+		v.errorf(append(p, "directory"), "must be a non-empty string")
+	}
+	if format, ok := archive["format"]; ok && format != "zip" && format != "tar" {
+		v.errorf(append(p, "format"), `must be "zip" or "tar"`)
+	}
+	for _, key := range []string{"absolute-directory", "prefix-url"} {
+		v.optionalString(archive, key, p...)
+	}
+	for _, key := range []string{"skip-dev", "checksum", "override-dist-type", "rearchive"} {
+		v.optionalBool(archive, key, p...)
+	}
+	for _, key := range []string{"whitelist", "blacklist"} {
+		list, ok := archive[key]
+		if !ok {
+			continue
+		}
+		names, ok := list.([]interface{})
+		if !ok {
+			v.errorf(append(p, key), "must be an array of package names")
+			continue
+		}
+		for i, name := range names {
+			if _, ok := name.(string); !ok {
+				v.errorf(append(p, key, i), "must be a string")
+			}
+		}
+	}
+}
+
+func (v *configValidator) optionalString(obj map[string]interface{}, key string, p ...interface{}) {
+	if value, ok := obj[key]; ok {
+		if _, ok := value.(string); !ok {
+			v.errorf(append(p, key), "must be a string")
+		}
+	}
+}
+
+func (v *configValidator) optionalBool(obj map[string]interface{}, key string, p ...interface{}) {
+	if value, ok := obj[key]; ok {
+		if _, ok := value.(bool); !ok {
+			v.errorf(append(p, key), "must be a boolean")
+		}
+	}
+}
+
+func contains(values []string, value interface{}) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// introducedErrors returns the errors of after which are not in before.
+func introducedErrors(before, after []ConfigError) []ConfigError {
+	known := make(map[ConfigError]bool, len(before))
+	for _, e := range before {
+		known[e] = true
+	}
+	var errs []ConfigError
+	for _, e := range after {
+		if !known[e] {
+			errs = append(errs, e)
+		}
+	}
+	return errs
+}
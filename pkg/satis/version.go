@@ -0,0 +1,116 @@
+package satis
+
+import (
+	"regexp"
+	"strings"
+)
+
+// The version formats Composer accepts, as its VersionParser defines them.
+var (
+	versionModifier = `[._-]?(?:(stable|beta|b|RC|alpha|a|patch|pl|p)((?:[.-]?\d+)*)?)?([.-]?dev)?`
+	versionPattern  = regexp.MustCompile(`(?i)^v?(\d{1,5})(\.\d+)?(\.\d+)?(\.\d+)?` + versionModifier + `$`)
+	datePattern     = regexp.MustCompile(`(?i)^v?(\d{4}(?:[.:-]?\d{2}){1,6}(?:[.:-]?\d{1,3}){0,2})` + versionModifier + `$`)
+	branchPattern   = regexp.MustCompile(`(?i)^v?(\d+)(\.(?:\d+|[x*]))?(\.(?:\d+|[x*]))?(\.(?:\d+|[x*]))?$`)
+	devPattern      = regexp.MustCompile(`(?i)^(.*?)[.-]?dev$`)
+	nonDigits       = regexp.MustCompile(`\D`)
+	wildcardParts   = regexp.MustCompile(`(\.9999999)+`)
+)
+
+// normalizeVersion returns the normalized form of the version, such as
+// "1.2.0.0-beta1" for "v1.2-b1", or false when Composer does not accept it.
+func normalizeVersion(version string) (string, bool) {
+	version = strings.TrimSpace(version)
+	if i := strings.Index(version, "+"); 0 < i {
+		// build metadata
+		version = version[:i]
+	}
+	lower := strings.ToLower(version)
+	if lower == "master" || lower == "trunk" || lower == "default" {
+		return "dev-" + version, true
+	}
+	if strings.HasPrefix(lower, "dev-") {
+		return "dev-" + version[4:], true
+	}
+
+	var normalized string
+	var modifiers []string
+	if m := versionPattern.FindStringSubmatch(version); m != nil {
+		normalized = m[1]
+		for _, part := range m[2:5] {
+			if part == "" {
+				part = ".0"
+			}
+			normalized += part
+		}
+		modifiers = m[5:]
+	} else if m := datePattern.FindStringSubmatch(version); m != nil {
+		normalized = nonDigits.ReplaceAllString(m[1], ".")
+		modifiers = m[2:]
+	} else if m := devPattern.FindStringSubmatch(version); m != nil {
+		if branch := normalizeBranch(m[1]); !strings.HasPrefix(branch, "dev-") {
+			return branch, true
+		}
+		return "", false
+	} else {
+		return "", false
+	}
+
+	if modifiers[0] != "" {
+		if strings.ToLower(modifiers[0]) == "stable" {
+			return normalized, true
+		}
+		normalized += "-" + expandStability(modifiers[0]) + strings.TrimLeft(modifiers[1], ".-")
+	}
+	if modifiers[2] != "" {
+		normalized += "-dev"
+	}
+	return normalized, true
+}
+
+// normalizeBranch returns the normalized version of the branch, such as
+// "1.9999999.9999999.9999999-dev" for "1.x", or "dev-" followed by the
+// name for the others.
+func normalizeBranch(name string) string {
+	name = strings.TrimSpace(name)
+	m := branchPattern.FindStringSubmatch(name)
+	if m == nil {
+		return "dev-" + name
+	}
+	version := m[1]
+	for _, part := range m[2:5] {
+		if part == "" {
+			part = ".x"
+		}
+		version += strings.NewReplacer("*", "x", "X", "x").Replace(part)
+	}
+	return strings.Replace(version, "x", "9999999", -1) + "-dev"
+}
+
+// branchVersion returns the version Composer shows for the branch, such as
+// "1.x-dev" or "dev-master", and its normalized form.
+func branchVersion(name string) (string, string) {
+	normalized := normalizeBranch(name)
+	if strings.HasPrefix(normalized, "dev-") {
+		return normalized, normalized
+	}
+	return wildcardParts.ReplaceAllString(normalized, ".x"), normalized
+}
+
+// isDevVersion determines whether the normalized version is a development one.
+func isDevVersion(normalized string) bool {
+	return strings.HasPrefix(normalized, "dev-") || strings.HasSuffix(normalized, "-dev")
+}
+
+func expandStability(stability string) string {
+	switch strings.ToLower(stability) {
+	case "a":
+		return "alpha"
+	case "b":
+		return "beta"
+	case "p", "pl":
+		return "patch"
+	case "rc":
+		return "RC"
+	}
+	return strings.ToLower(stability)
+}
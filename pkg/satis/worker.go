@@ -0,0 +1,81 @@
+package satis
+
+import "context"
+
+// acceptPartial determines whether the service takes another partial build
+// request while the given number of batches wait for a worker.
+func (s *service) acceptPartial(waiting int) bool {
+	return len(s.runningBuilds()) < s.partialWorkers() && waiting < cap(s.cmdPartial)
+}
+
+// partialWorkers returns the number of the partial builds to run at once.
+// Concurrent satis builds would overwrite packages.json and the include
+// files of each other, so only the native builder runs them in parallel.
+func (s *service) partialWorkers() int {
+	if s.native == nil {
+		return 1
+	}
+	return s.workers
+}
+
+// startPartials starts the waiting batches as far as the workers allow and
+// returns the rest. A batch waits while a build of any of its packages runs,
+// so that the builds of a package run in order. A batch turning into a full
+// rebuild runs alone, and the later batches wait for it.
+func (s *service) startPartials(ctx context.Context, waiting [][]requestPartial, result chan<- ServiceResult) [][]requestPartial {
+	running := s.runningBuilds()
+	busy := make(map[string]bool)
+	exclusive := false
+	for _, b := range running {
+		exclusive = exclusive || b.exclusive
+		for _, name := range b.packages {
+			busy[name] = true
+		}
+	}
+	count := len(running)
+	workers := s.partialWorkers()
+
+	var rest [][]requestPartial
+	blocked := false
+	for _, batch := range waiting {
+		names, full := s.buildTargets(mergePackages(batch))
+		conflict := false
+		for _, name := range names {
+			conflict = conflict || busy[name]
+			busy[name] = true
+		}
+
+		if blocked || exclusive || conflict || workers <= count || (full && 0 < count) {
+			rest = append(rest, batch)
+			blocked = blocked || full
+			continue
+		}
+
+		jobIDs := make([]string, len(batch))
+		for i, req := range batch {
+			jobIDs[i] = req.JobID
+		}
+		batch := batch
+		s.startBuild(ctx, &build{jobIDs: jobIDs, packages: names, exclusive: full}, func(ctx context.Context, b *build) {
+			s.runPartials(ctx, b, batch, result)
+		})
+		count++
+		exclusive = full
+	}
+	return rest
+}
+
+// discardWaiting drops the waiting batches, replying reason as their results.
+// It returns the dropped requests which have not been cancelled.
+func (s *service) discardWaiting(waiting [][]requestPartial, reason error) []requestPartial {
+	var discarded []requestPartial
+	for _, batch := range waiting {
+		for _, req := range batch {
+			if !s.jobs.cancelled(req.JobID) {
+				discarded = append(discarded, req)
+			}
+			s.discardRequest(req.JobID, req.Result, reason)
+		}
+	}
+	return discarded
+}